@@ -0,0 +1,274 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zpstore"
+)
+
+// clubResults are the riders from a club's latest import
+type clubResults struct {
+	Updated  time.Time
+	Profiles []zp.RiderProfile
+}
+
+// latestResults keeps each club's most recent import in memory, for the API
+type latestResults struct {
+	mu    sync.Mutex
+	clubs map[int]clubResults
+}
+
+var latest = &latestResults{clubs: map[int]clubResults{}}
+
+func (l *latestResults) set(clubID int, profiles []zp.RiderProfile, updated time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clubs[clubID] = clubResults{Updated: updated, Profiles: profiles}
+}
+
+func (l *latestResults) club(clubID int) (clubResults, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clubs[clubID]
+	return c, ok
+}
+
+// rider finds the rider in whichever club's results were updated most recently
+func (l *latestResults) rider(zwid int) (zp.RiderProfile, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found zp.RiderProfile
+	var updated time.Time
+	ok := false
+	for _, c := range l.clubs {
+		for _, p := range c.Profiles {
+			if p.Rider.Zwid == zwid && (!ok || c.Updated.After(updated)) {
+				found, updated, ok = p, c.Updated, true
+			}
+		}
+	}
+	return found, ok
+}
+
+// apiEvent is an event as the API gives it. Values that ZwiftPower didn't supply are null.
+type apiEvent struct {
+	EventID         string     `json:"event_id"`
+	EventTitle      string     `json:"event_title"`
+	EventType       string     `json:"event_type"`
+	EventDate       *time.Time `json:"event_date"`
+	IsRace          bool       `json:"is_race"`
+	Category        string     `json:"category"`
+	Position        int        `json:"position"`
+	PositionInCat   int        `json:"position_in_cat"`
+	Seconds         *float64   `json:"seconds"`
+	Distance        *float64   `json:"distance_km"`
+	AvgWkg          *float64   `json:"avg_wkg"`
+	WkgFtp          *float64   `json:"wkg_ftp"`
+	WattsFtp        *float64   `json:"watts_ftp"`
+	AvgPower        *float64   `json:"avg_power"`
+	NormalizedPower *float64   `json:"normalized_power"`
+	AvgHR           *float64   `json:"avg_hr"`
+	MaxHR           *float64   `json:"max_hr"`
+	Weight          *float64   `json:"weight"`
+	Watts5          *float64   `json:"watts5"`
+	Watts60         *float64   `json:"watts60"`
+	Watts300        *float64   `json:"watts300"`
+	Watts1200       *float64   `json:"watts1200"`
+	Wkg5            *float64   `json:"wkg5"`
+	Wkg60           *float64   `json:"wkg60"`
+	Wkg300          *float64   `json:"wkg300"`
+	Wkg1200         *float64   `json:"wkg1200"`
+}
+
+func newAPIEvent(e zp.Event) apiEvent {
+	var date *time.Time
+	if !e.EventDate.IsZero() {
+		d := e.EventDate.UTC()
+		date = &d
+	}
+
+	return apiEvent{
+		EventID:         string(e.EventID),
+		EventTitle:      e.EventTitle,
+		EventType:       e.EventType,
+		EventDate:       date,
+		IsRace:          e.IsRace(),
+		Category:        e.Category,
+		Position:        e.Position,
+		PositionInCat:   e.PositionInCat,
+		Seconds:         tupleValue(e.Time),
+		Distance:        tupleValue(e.Distance),
+		AvgWkg:          tupleValue(e.AvgWkg),
+		WkgFtp:          tupleValue(e.WkgFtp),
+		WattsFtp:        tupleValue(e.WattsFtp),
+		AvgPower:        tupleValue(e.AvgPower),
+		NormalizedPower: tupleValue(e.NormalizedPower),
+		AvgHR:           tupleValue(e.AvgHR),
+		MaxHR:           tupleValue(e.MaxHR),
+		Weight:          tupleValue(e.Weight),
+		Watts5:          tupleValue(e.Watts5),
+		Watts60:         tupleValue(e.Watts60),
+		Watts300:        tupleValue(e.Watts300),
+		Watts1200:       tupleValue(e.Watts1200),
+		Wkg5:            tupleValue(e.Wkg5),
+		Wkg60:           tupleValue(e.Wkg60),
+		Wkg300:          tupleValue(e.Wkg300),
+		Wkg1200:         tupleValue(e.Wkg1200),
+	}
+}
+
+// clubResponse is what GET /clubs/<id>/riders gives
+type clubResponse struct {
+	ClubID  int         `json:"club_id"`
+	Updated time.Time   `json:"updated"`
+	Riders  []zp.Record `json:"riders"`
+}
+
+// APIHandler serves the latest imported data as JSON, read-only:
+//
+//	GET /clubs/<id>/riders     the club's riders, with the output columns
+//	GET /riders/<zwid>         one rider
+//	GET /riders/<zwid>/events  the rider's events, oldest first
+//
+// Data comes from the service's latest imports, or from the database (--db) for clubs and riders it hasn't
+// imported since it started.
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.Error(w, "bad ID", http.StatusBadRequest)
+		return
+	}
+
+	var v interface{}
+	switch {
+	case parts[0] == "clubs" && len(parts) == 3 && parts[2] == "riders":
+		v, err = clubRiders(id)
+	case parts[0] == "riders" && len(parts) == 2:
+		v, err = rider(id)
+	case parts[0] == "riders" && len(parts) == 3 && parts[2] == "events":
+		v, err = riderEvents(id)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func clubRiders(clubID int) (interface{}, error) {
+	resp := clubResponse{ClubID: clubID, Riders: []zp.Record{}}
+	if c, ok := latest.club(clubID); ok {
+		resp.Updated = c.Updated
+		for _, p := range c.Profiles {
+			resp.Riders = append(resp.Riders, Schema.Record(p.Rider))
+		}
+		return resp, nil
+	}
+
+	return withStore(func(store *zpstore.Store) (interface{}, error) {
+		runs, err := store.Runs(clubID)
+		if err != nil || len(runs) == 0 {
+			return nil, err
+		}
+		riders, err := store.Snapshot(runs[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		resp.Updated = runs[0].StartedAt
+		for _, r := range riders {
+			resp.Riders = append(resp.Riders, Schema.Record(r))
+		}
+		return resp, nil
+	})
+}
+
+func rider(zwid int) (interface{}, error) {
+	if p, ok := latest.rider(zwid); ok {
+		return Schema.Record(p.Rider), nil
+	}
+
+	return withStore(func(store *zpstore.Store) (interface{}, error) {
+		r, ok, err := store.LatestSnapshot(zwid)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return Schema.Record(r), nil
+	})
+}
+
+func riderEvents(zwid int) (interface{}, error) {
+	toAPI := func(events []zp.Event) []apiEvent {
+		api := make([]apiEvent, len(events))
+		for i, e := range events {
+			api[i] = newAPIEvent(e)
+		}
+		return api
+	}
+
+	if p, ok := latest.rider(zwid); ok {
+		return toAPI(p.Events), nil
+	}
+
+	return withStore(func(store *zpstore.Store) (interface{}, error) {
+		events, err := store.Events(zwid)
+		if err != nil || len(events) == 0 {
+			return nil, err
+		}
+		return toAPI(events), nil
+	})
+}
+
+// apiStore is the database the API reads from. It's opened the first time it's needed and kept open, rather than
+// opened (and its tables checked) for every request.
+var apiStore struct {
+	mu       sync.Mutex
+	filename string
+	store    *zpstore.Store
+}
+
+// withStore calls fn with the database, if there is one. Otherwise there's nothing to find.
+func withStore(fn func(store *zpstore.Store) (interface{}, error)) (interface{}, error) {
+	if DB == "" {
+		return nil, nil
+	}
+
+	apiStore.mu.Lock()
+	if apiStore.store == nil || apiStore.filename != DB {
+		if apiStore.store != nil {
+			apiStore.store.Close()
+			apiStore.store = nil
+		}
+		store, err := zpstore.Open(DB)
+		if err != nil {
+			apiStore.mu.Unlock()
+			return nil, err
+		}
+		apiStore.filename, apiStore.store = DB, store
+	}
+	store := apiStore.store
+	apiStore.mu.Unlock()
+	return fn(store)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+)
+
+func TestAPI(t *testing.T) {
+	fixtures := zptest.NewServer("zp/testdata")
+	defer fixtures.Close()
+
+	dir := t.TempDir()
+	BaseURL = fixtures.URL
+	Filename = filepath.Join(dir, "results.csv")
+	DB = filepath.Join(dir, "api.db")
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+		DB = ""
+	}()
+
+	if _, err := ZwiftPower(flagOptions(2672)); err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clubs/", APIHandler)
+	mux.HandleFunc("/riders/", APIHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("Decoding %s: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var latestEvent interface{}
+	check := func(source string) {
+		var club struct {
+			ClubID int                      `json:"club_id"`
+			Riders []map[string]interface{} `json:"riders"`
+		}
+		if status := get("/clubs/2672/riders", &club); status != http.StatusOK {
+			t.Fatalf("%s: got status %d for club", source, status)
+		}
+		if club.ClubID != 2672 || len(club.Riders) == 0 {
+			t.Fatalf("%s: unexpected club %+v", source, club)
+		}
+
+		var rider map[string]interface{}
+		if status := get("/riders/98588", &rider); status != http.StatusOK {
+			t.Fatalf("%s: got status %d for rider", source, status)
+		}
+		if rider["name"] != "Liz Rice" || rider["zwid"] != 98588.0 || rider["latest_event"] == "" {
+			t.Errorf("%s: unexpected rider %v", source, rider)
+		}
+		if latestEvent == nil {
+			latestEvent = rider["latest_event"]
+		} else if rider["latest_event"] != latestEvent {
+			t.Errorf("%s: got latest event %v, want %v", source, rider["latest_event"], latestEvent)
+		}
+
+		var events []apiEvent
+		if status := get("/riders/98588/events", &events); status != http.StatusOK {
+			t.Fatalf("%s: got status %d for events", source, status)
+		}
+		if len(events) == 0 || events[0].EventID == "" || events[0].WattsFtp == nil {
+			t.Errorf("%s: unexpected events %+v", source, events)
+		}
+	}
+
+	// Straight after the import, from memory
+	check("memory")
+
+	// After a restart, from the database
+	latest = &latestResults{clubs: map[int]clubResults{}}
+	check("database")
+
+	// The database is opened once and kept for later requests
+	first := apiStore.store
+	check("database again")
+	if first == nil || apiStore.store != first {
+		t.Errorf("The database was opened again")
+	}
+
+	for path, want := range map[string]int{
+		"/clubs/2740/riders":  http.StatusNotFound,
+		"/riders/1":           http.StatusNotFound,
+		"/riders/liz":         http.StatusBadRequest,
+		"/riders/98588/other": http.StatusNotFound,
+		"/clubs/2672":         http.StatusNotFound,
+	} {
+		if status := get(path, nil); status != want {
+			t.Errorf("%s: got status %d, want %d", path, status, want)
+		}
+	}
+}
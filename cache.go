@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hermannatorii/zwiftpower/zp"
+)
+
+// bucketCache is a zp.CacheStore that keeps responses as objects under prefix in the storage bucket
+type bucketCache struct {
+	prefix string
+}
+
+// newCache gets a cache under CacheDir: in the storage bucket if we're using one, otherwise on disk.
+// It returns nil if caching is off.
+func newCache() *zp.Cache {
+	if CacheDir == "" {
+		return nil
+	}
+
+	cache := &zp.Cache{
+		Store:      zp.DiskCache{Dir: CacheDir},
+		TeamTTL:    TeamTTL,
+		ProfileTTL: ProfileTTL,
+	}
+	if storageClient != nil {
+		cache.Store = bucketCache{prefix: CacheDir}
+	}
+	return cache
+}
+
+// Get reads the object for key, using its update time as the time it was stored
+func (b bucketCache) Get(key string) ([]byte, time.Time, bool, error) {
+	ctx := context.Background()
+	obj := storageClient.Bucket(bucketName).Object(path.Join(b.prefix, key))
+	r, err := obj.NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return data, r.Attrs.LastModified, true, nil
+}
+
+// Put writes the object for key
+func (b bucketCache) Put(key string, data []byte) error {
+	ctx := context.Background()
+	w := storageClient.Bucket(bucketName).Object(path.Join(b.prefix, key)).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
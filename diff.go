@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zpstore"
+)
+
+// DiffSnapshots writes a CSV report of what changed between two snapshots of a club. Each snapshot is a run ID in
+// the database, if there is one, or the name of a CSV results file. If both are empty, the club's two most recent
+// runs in the database are compared.
+func DiffSnapshots(clubID int, oldName, newName string, opts zp.DiffOptions, w io.Writer) error {
+	var store *zpstore.Store
+	if DB != "" {
+		var err error
+		store, err = zpstore.Open(DB)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+	}
+
+	if oldName == "" && newName == "" {
+		if store == nil {
+			return fmt.Errorf("need two snapshots to compare, or a database to find them in")
+		}
+		runs, err := store.Runs(clubID)
+		if err != nil {
+			return err
+		}
+		if len(runs) < 2 {
+			return fmt.Errorf("need two runs for club %d to compare, but there are %d", clubID, len(runs))
+		}
+		oldName, newName = strconv.FormatInt(runs[1].ID, 10), strconv.FormatInt(runs[0].ID, 10)
+	}
+
+	old, err := loadSnapshot(store, oldName)
+	if err != nil {
+		return err
+	}
+	new, err := loadSnapshot(store, newName)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(zp.DiffHeader)
+	cw.WriteAll(zp.Diff(old, new, opts).Rows())
+	return cw.Error()
+}
+
+// loadSnapshot reads the riders from a stored run, or from a CSV file. Riders from a file are taken to be as of
+// the time the file was written.
+func loadSnapshot(store *zpstore.Store, name string) ([]zp.Rider, error) {
+	if runID, err := strconv.ParseInt(name, 10, 64); err == nil && store != nil {
+		riders, err := store.Snapshot(runID)
+		if err != nil {
+			return nil, fmt.Errorf("reading run %d: %v", runID, err)
+		}
+		if len(riders) == 0 {
+			return nil, fmt.Errorf("no riders in run %d", runID)
+		}
+		return riders, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	riders, err := zp.ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", name, err)
+	}
+	for i := range riders {
+		riders[i].AsOf = info.ModTime()
+	}
+	return riders, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus is where a job has got to
+type JobStatus string
+
+// Job statuses
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an import running in the background, started with POST /jobs
+type Job struct {
+	ID     string    `json:"id"`
+	ClubID int       `json:"club_id"`
+	Status JobStatus `json:"status"`
+	// Done and Total count riders, so Done/Total is the progress
+	Done     int `json:"done"`
+	Total    int `json:"total"`
+	Imported int `json:"imported"`
+	// Failures describe riders who couldn't be imported
+	Failures []string `json:"failures,omitempty"`
+	// Error is why the job failed, if it did
+	Error string `json:"error,omitempty"`
+	// Output is where the results are written
+	Output   string     `json:"output"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// done is closed when the job finishes, and summary is what it did
+	done    chan struct{}
+	summary Summary
+}
+
+// maxJobs is how many jobs we remember. The oldest finished ones are forgotten first.
+const maxJobs = 100
+
+// jobQueue runs jobs, at most one at a time per club, as they share the club's state and member list, and at
+// most one at a time per destination, so that clubs don't overwrite each other's results
+type jobQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	// running has the unfinished job for each club, and writing has it for each destination
+	running map[int]*Job
+	writing map[string]*Job
+	// run does the import; it's importClub except in tests
+	run func(opts Options, progress func(done, total int)) (Summary, error)
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		jobs:    map[string]*Job{},
+		running: map[int]*Job{},
+		writing: map[string]*Job{},
+		run:     importClub,
+	}
+}
+
+// jobs is the service's job queue, which both /jobs and /trigger use
+var jobs = newJobQueue()
+
+// start starts a job with the options, unless there's one running for the club already, in which case that's
+// returned instead. started says which. If the running job is writing somewhere else, or another club's job is
+// writing to the same place, it's an error.
+func (q *jobQueue) start(opts Options) (job Job, started bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	output := outputLocation(opts)
+	if j, ok := q.running[opts.ClubID]; ok {
+		if j.Output != output {
+			return *j, false, fmt.Errorf("club %d already has job %s running, writing to %s", j.ClubID, j.ID, j.Output)
+		}
+		return *j, false, nil
+	}
+	if j, ok := q.writing[output]; ok {
+		return *j, false, fmt.Errorf("job %s for club %d is already writing to %s", j.ID, j.ClubID, output)
+	}
+
+	j := &Job{
+		ID:      newJobID(),
+		ClubID:  opts.ClubID,
+		Status:  JobRunning,
+		Output:  output,
+		Started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	q.jobs[j.ID] = j
+	q.running[opts.ClubID] = j
+	q.writing[output] = j
+	q.prune()
+
+	go q.do(j, opts)
+	return *j, true, nil
+}
+
+// wait waits for the job to finish, or for ctx to be done, and gives the job as it is then
+func (q *jobQueue) wait(ctx context.Context, job Job) Job {
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.jobs[job.ID]; ok {
+		return *j
+	}
+	return job
+}
+
+func (q *jobQueue) do(j *Job, opts Options) {
+	log.Printf("Starting job %s for club %d", j.ID, j.ClubID)
+	summary, err := q.run(opts, func(done, total int) {
+		q.mu.Lock()
+		j.Done, j.Total = done, total
+		q.mu.Unlock()
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	j.Finished = &now
+	j.summary = summary
+	j.Imported = summary.Imported
+	for _, f := range summary.Failures {
+		j.Failures = append(j.Failures, fmt.Sprintf("%s (%d): %v", f.Name, f.Zwid, f.Err))
+	}
+	j.Status = JobSucceeded
+	if err != nil {
+		j.Status, j.Error = JobFailed, err.Error()
+	}
+	delete(q.running, j.ClubID)
+	delete(q.writing, j.Output)
+	close(j.done)
+	log.Printf("Job %s for club %d %s", j.ID, j.ClubID, j.Status)
+}
+
+// get gives a copy of the job, so it can be read without holding the lock
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// list gives copies of all the jobs, most recent first
+func (q *jobQueue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Started.After(jobs[k].Started) })
+	return jobs
+}
+
+// prune forgets the oldest finished jobs once there are more than maxJobs. It must be called with the lock held.
+func (q *jobQueue) prune() {
+	for len(q.jobs) > maxJobs {
+		var oldest *Job
+		for _, j := range q.jobs {
+			if j.Finished != nil && (oldest == nil || j.Started.Before(oldest.Started)) {
+				oldest = j
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(q.jobs, oldest.ID)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// outputLocation describes where the options say the results go
+func outputLocation(opts Options) string {
+	switch {
+	case opts.SpreadsheetID != "":
+		return fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s (%s)", opts.SpreadsheetID, opts.SpreadsheetSheet)
+	case storageClient != nil && opts.Filename != "":
+		return fmt.Sprintf("gs://%s/%s", bucketName, opts.Filename)
+	case storageClient != nil:
+		return fmt.Sprintf("gs://%s/results.%s", bucketName, outputExtension(opts.Format))
+	case opts.Filename != "":
+		return opts.Filename
+	}
+	return "stdout"
+}
+
+// ServeHTTP handles POST /jobs to start a job, taking the same parameters as /trigger; GET /jobs to list jobs;
+// and GET /jobs/<id> for a job's status. Starting a job for a club that already has one running gives the
+// running job rather than starting another, or a conflict if the running job is writing somewhere else. Starting
+// a job that would write where another club's job is writing is a conflict too.
+func (q *jobQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		opts, status, err := triggerOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		job, started, err := q.start(opts)
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		status = http.StatusOK
+		if started {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, job)
+
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, q.list())
+
+	case id != "" && r.Method == http.MethodGet:
+		job, ok := q.get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Writing response: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+)
+
+func TestJobs(t *testing.T) {
+	release := make(chan struct{})
+	q := newJobQueue()
+	q.run = func(opts Options, progress func(done, total int)) (Summary, error) {
+		progress(1, 2)
+		<-release
+		progress(2, 2)
+		if opts.ClubID == 2740 {
+			return Summary{}, fmt.Errorf("no such club")
+		}
+		return Summary{ClubID: opts.ClubID, Imported: 1, Failures: []zp.Failure{{Name: "Liz", Zwid: 98588, Err: fmt.Errorf("gone")}}}, nil
+	}
+	srv := httptest.NewServer(q)
+	defer srv.Close()
+
+	post := func(query string) (Job, int) {
+		resp, err := http.Post(srv.URL+"/jobs"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var job Job
+		json.NewDecoder(resp.Body).Decode(&job)
+		return job, resp.StatusCode
+	}
+	get := func(id string) (Job, int) {
+		resp, err := http.Get(srv.URL + "/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var job Job
+		json.NewDecoder(resp.Body).Decode(&job)
+		return job, resp.StatusCode
+	}
+
+	job, status := post("")
+	if status != http.StatusAccepted || job.ID == "" || job.ClubID != 2672 || job.Status != JobRunning {
+		t.Fatalf("Got %d %+v", status, job)
+	}
+
+	// The club already has a job running, so we get that one back
+	again, status := post("?club=2672")
+	if status != http.StatusOK || again.ID != job.ID {
+		t.Errorf("Expected the running job back, got %d %+v", status, again)
+	}
+
+	// But not if it'd be writing somewhere else
+	os.Setenv("ALLOWED_SPREADSHEETS", "other")
+	defer os.Unsetenv("ALLOWED_SPREADSHEETS")
+	if _, status := post("?club=2672&spreadsheet=other&sheet=Riders"); status != http.StatusConflict {
+		t.Errorf("Got %d for a job writing somewhere else", status)
+	}
+
+	// Wait for some progress
+	deadline := time.Now().Add(time.Second)
+	for job.Done != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		job, _ = get(job.ID)
+	}
+	if job.Done != 1 || job.Total != 2 {
+		t.Errorf("Expected progress 1/2, got %+v", job)
+	}
+
+	// Another club can't write to the same place at the same time
+	os.Setenv("ALLOWED_CLUBS", "2740,1234")
+	defer os.Unsetenv("ALLOWED_CLUBS")
+	if _, status := post("?club=2740"); status != http.StatusConflict {
+		t.Errorf("Got %d for another club writing to the same place", status)
+	}
+
+	other, status := post("?club=2740&spreadsheet=other&sheet=Riders")
+	if status != http.StatusAccepted || other.ID == job.ID {
+		t.Errorf("Expected a new job for another club, got %d %+v", status, other)
+	}
+	if _, status := post("?club=1234&spreadsheet=other&sheet=Riders"); status != http.StatusConflict {
+		t.Errorf("Got %d for two clubs writing to the same sheet", status)
+	}
+
+	close(release)
+	deadline = time.Now().Add(time.Second)
+	for (job.Finished == nil || other.Finished == nil) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		job, _ = get(job.ID)
+		other, _ = get(other.ID)
+	}
+	if job.Status != JobSucceeded || job.Imported != 1 || len(job.Failures) != 1 || job.Output != "stdout" {
+		t.Errorf("Unexpected finished job %+v", job)
+	}
+	if other.Status != JobFailed || other.Error != "no such club" {
+		t.Errorf("Unexpected failed job %+v", other)
+	}
+
+	if _, status := get("nonsense"); status != http.StatusNotFound {
+		t.Errorf("Got %d for a missing job", status)
+	}
+
+	// Once the job's finished, a new one can start
+	if next, status := post(""); status != http.StatusAccepted || next.ID == job.ID {
+		t.Errorf("Expected a new job, got %d %+v", status, next)
+	}
+}
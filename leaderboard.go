@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zpstore"
+)
+
+// sparklineEvents is how many of a rider's most recent events their sparkline shows
+const sparklineEvents = 10
+
+// Sparkline size, in pixels
+const (
+	sparklineWidth  = 100
+	sparklineHeight = 20
+)
+
+// leaderboardSorts are the columns the leaderboard can be sorted by. less puts the rider who should come first by
+// default ahead: the fastest, the busiest or the most recently seen.
+var leaderboardSorts = map[string]func(a, b zp.Rider) bool{
+	"name": func(a, b zp.Rider) bool {
+		return strings.ToLower(html.UnescapeString(a.Name)) < strings.ToLower(html.UnescapeString(b.Name))
+	},
+	"ftp30":      func(a, b zp.Rider) bool { return a.Ftp30 > b.Ftp30 },
+	"ftp90":      func(a, b zp.Rider) bool { return a.Ftp90 > b.Ftp90 },
+	"races30":    func(a, b zp.Rider) bool { return a.Races30 > b.Races30 },
+	"races90":    func(a, b zp.Rider) bool { return a.Races90 > b.Races90 },
+	"last_event": func(a, b zp.Rider) bool { return a.LatestEventDate.After(b.LatestEventDate) },
+}
+
+// leaderboardRow is a rider's line on the leaderboard
+type leaderboardRow struct {
+	Rider      zp.Rider
+	ProfileURL string
+	LastSeen   string
+	// Sparkline is the SVG polyline of their recent average W/kg, oldest first, and Wkg are the values
+	Sparkline string
+	Wkg       []float64
+}
+
+// leaderboardColumn is a sortable column heading
+type leaderboardColumn struct {
+	Title string
+	Link  string
+	// Arrow shows if the table is sorted by this column, and which way
+	Arrow string
+}
+
+var leaderboardTemplate = template.Must(template.New("leaderboard").Funcs(template.FuncMap{
+	"wkg": func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) },
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	},
+	"inc": func(i int) int { return i + 1 },
+	// ZwiftPower sends names already HTML-encoded, and the template would encode them again
+	"unescape": html.UnescapeString,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Club {{.ClubID}} leaderboard</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; text-align: left; }
+th a { color: inherit; text-decoration: none; }
+td.n { text-align: right; }
+polyline { fill: none; stroke: #fc6719; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>Club {{.ClubID}} leaderboard</h1>
+<p>Updated {{.Updated.UTC.Format "2006-01-02 15:04"}} UTC</p>
+<table>
+<tr><th>#</th>{{range .Columns}}<th><a href="{{.Link}}">{{.Title}}{{.Arrow}}</a></th>{{end}}<th>Category</th><th>Recent W/kg</th></tr>
+{{- range $i, $row := .Rows}}
+<tr>
+<td class="n">{{inc $i}}</td>
+<td><a href="{{.ProfileURL}}">{{unescape .Rider.Name}}</a></td>
+<td class="n">{{wkg .Rider.Ftp30}}</td>
+<td class="n">{{wkg .Rider.Ftp90}}</td>
+<td class="n">{{.Rider.Races30}}</td>
+<td class="n">{{.Rider.Races90}}</td>
+<td>{{date .Rider.LatestEventDate}} {{unescape .Rider.LatestEvent}}<br><small>{{.LastSeen}}</small></td>
+<td>{{.Rider.Category.Category}}{{if .Rider.Category.UpgradeRisk}} ↑{{end}}</td>
+<td>{{if .Sparkline}}<svg width="{{$.Width}}" height="{{$.Height}}"><title>{{range .Wkg}}{{wkg .}} {{end}}</title><polyline points="{{.Sparkline}}"/></svg>{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// LeaderboardHandler serves GET /leaderboard/<club ID> as an HTML page of the club's riders from its latest import.
+// ?sort= orders it by name, ftp30, ftp90, races30, races90 or last_event (ftp30 by default), and ?order=reverse
+// turns it round.
+func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clubID, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/leaderboard"), "/"))
+	if err != nil {
+		http.Error(w, "bad club ID", http.StatusBadRequest)
+		return
+	}
+
+	by := r.URL.Query().Get("sort")
+	if by == "" {
+		by = "ftp30"
+	}
+	less, ok := leaderboardSorts[by]
+	if !ok {
+		http.Error(w, fmt.Sprintf("can't sort by %q", by), http.StatusBadRequest)
+		return
+	}
+	reverse := r.URL.Query().Get("order") == "reverse"
+
+	profiles, updated, err := clubProfiles(clubID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if profiles == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	sort.SliceStable(profiles, func(i, k int) bool {
+		if reverse {
+			return less(profiles[k].Rider, profiles[i].Rider)
+		}
+		return less(profiles[i].Rider, profiles[k].Rider)
+	})
+
+	rows := make([]leaderboardRow, len(profiles))
+	for i, p := range profiles {
+		wkg := recentWkg(p.Events, p.Rider.AsOf, sparklineEvents)
+		rows[i] = leaderboardRow{
+			Rider:      p.Rider,
+			ProfileURL: zp.ProfileURL(p.Rider.Zwid),
+			LastSeen:   p.Rider.Recency().Format(Lang),
+			Sparkline:  sparkline(wkg, sparklineWidth, sparklineHeight),
+			Wkg:        wkg,
+		}
+	}
+
+	var columns []leaderboardColumn
+	for _, c := range []struct{ key, title string }{
+		{"name", "Name"},
+		{"ftp30", "FTP 30 days"},
+		{"ftp90", "FTP 90 days"},
+		{"races30", "Races 30 days"},
+		{"races90", "Races 90 days"},
+		{"last_event", "Last event"},
+	} {
+		col := leaderboardColumn{Title: c.title, Link: "?sort=" + c.key}
+		if c.key == by {
+			col.Arrow = " ▼"
+			if !reverse {
+				col.Link += "&order=reverse"
+			} else {
+				col.Arrow = " ▲"
+			}
+		}
+		columns = append(columns, col)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = leaderboardTemplate.Execute(w, struct {
+		ClubID  int
+		Updated time.Time
+		Columns []leaderboardColumn
+		Rows    []leaderboardRow
+		Width   int
+		Height  int
+	}{clubID, updated, columns, rows, sparklineWidth, sparklineHeight})
+	if err != nil {
+		log.Printf("Writing leaderboard for club %d: %v", clubID, err)
+	}
+}
+
+// clubProfiles gives the club's riders and their events from the latest import, either from memory or from the
+// database. It gives nil if there hasn't been one.
+func clubProfiles(clubID int) ([]zp.RiderProfile, time.Time, error) {
+	if c, ok := latest.club(clubID); ok {
+		profiles := make([]zp.RiderProfile, len(c.Profiles))
+		copy(profiles, c.Profiles)
+		return profiles, c.Updated, nil
+	}
+
+	var updated time.Time
+	v, err := withStore(func(store *zpstore.Store) (interface{}, error) {
+		runs, err := store.Runs(clubID)
+		if err != nil || len(runs) == 0 {
+			return nil, err
+		}
+		riders, err := store.Snapshot(runs[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		updated = runs[0].StartedAt
+
+		events, err := store.RunEvents(runs[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		profiles := make([]zp.RiderProfile, len(riders))
+		for i, r := range riders {
+			profiles[i] = zp.RiderProfile{Rider: r, Events: events[r.Zwid]}
+		}
+		return profiles, nil
+	})
+	if err != nil || v == nil {
+		return nil, updated, err
+	}
+	return v.([]zp.RiderProfile), updated, nil
+}
+
+// recentWkg gives the average W/kg from up to n of the most recent events up to asOf that have it, oldest first.
+// A zero asOf means any time.
+func recentWkg(events []zp.Event, asOf time.Time, n int) []float64 {
+	var recent []zp.Event
+	for _, e := range events {
+		if e.AvgWkg.Valid && !e.EventDate.IsZero() && (asOf.IsZero() || !e.EventDate.After(asOf)) {
+			recent = append(recent, e)
+		}
+	}
+	sort.SliceStable(recent, func(i, k int) bool { return recent[i].EventDate.Before(recent[k].EventDate) })
+	if len(recent) > n {
+		recent = recent[len(recent)-n:]
+	}
+
+	wkg := make([]float64, len(recent))
+	for i, e := range recent {
+		wkg[i] = e.AvgWkg.Value
+	}
+	return wkg
+}
+
+// sparkline gives the points of an SVG polyline plotting the values across a width x height box, or "" if there
+// aren't enough values to draw a line
+func sparkline(values []float64, width, height int) string {
+	if len(values) < 2 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	// Leave a pixel at the top and bottom so the line isn't clipped
+	h := float64(height - 2)
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := float64(width) * float64(i) / float64(len(values)-1)
+		y := 1 + h/2
+		if max > min {
+			y = 1 + h*(max-v)/(max-min)
+		}
+		points[i] = strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+)
+
+func TestLeaderboard(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 18, 0, 0, 0, time.UTC) }
+	wkg := func(v float64) zp.Tuple { return zp.Tuple{Value: v, Valid: true} }
+
+	defer func() { latest = &latestResults{clubs: map[int]clubResults{}} }()
+	latest = &latestResults{clubs: map[int]clubResults{}}
+	latest.set(2672, []zp.RiderProfile{
+		{
+			Rider: zp.Rider{Name: "Liz Rice", Zwid: 98588, Ftp30: 3.1, Ftp90: 3.4, Races30: 2, LatestEventDate: day(10),
+				AsOf: day(10)},
+			Events: []zp.Event{
+				{EventDate: day(3), AvgWkg: wkg(2.8)},
+				{EventDate: day(10), AvgWkg: wkg(3.0)},
+				{EventDate: day(6), AvgWkg: wkg(2.5)},
+				// After the stats were worked out, so it's not in the sparkline
+				{EventDate: day(11), AvgWkg: wkg(4.0)},
+			},
+		},
+		{
+			Rider: zp.Rider{Name: "<Ann>", Zwid: 1234, Ftp30: 3.5, Ftp90: 3.2, Races30: 1, LatestEventDate: day(1)},
+		},
+		{
+			// As ZwiftPower sends it, in zp/testdata/teams/2672_riders.json
+			Rider: zp.Rider{Name: "&Ouml;zge Yazar [REVO]", Zwid: 1261784, Ftp30: 2.9, LatestEventDate: day(2)},
+		},
+	}, day(11))
+
+	srv := httptest.NewServer(http.HandlerFunc(LeaderboardHandler))
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, page := get("/leaderboard/2672")
+	if status != http.StatusOK {
+		t.Fatalf("Got status %d: %s", status, page)
+	}
+	for _, want := range []string{
+		`href="https://www.zwiftpower.com/profile.php?z=98588"`,
+		"&lt;Ann&gt;",
+		">Özge Yazar [REVO]</a>",
+		`<polyline points="0.0,8.2 50.0,19.0 100.0,1.0"/>`,
+		`href="?sort=ftp30&amp;order=reverse"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Page doesn't have %s:\n%s", want, page)
+		}
+	}
+	// By FTP over 30 days, Ann comes first
+	if strings.Index(page, "&lt;Ann&gt;") > strings.Index(page, "Liz Rice") {
+		t.Errorf("Expected Ann first:\n%s", page)
+	}
+
+	_, page = get("/leaderboard/2672?sort=ftp90")
+	if strings.Index(page, "Liz Rice") > strings.Index(page, "&lt;Ann&gt;") {
+		t.Errorf("Expected Liz first by 90 day FTP:\n%s", page)
+	}
+	_, page = get("/leaderboard/2672?sort=ftp90&order=reverse")
+	if strings.Index(page, "&lt;Ann&gt;") > strings.Index(page, "Liz Rice") {
+		t.Errorf("Expected Ann first by 90 day FTP reversed:\n%s", page)
+	}
+
+	for path, want := range map[string]int{
+		"/leaderboard/2740":             http.StatusNotFound,
+		"/leaderboard/club":             http.StatusBadRequest,
+		"/leaderboard/2672?sort=weight": http.StatusBadRequest,
+	} {
+		if status, _ := get(path); status != want {
+			t.Errorf("%s: got status %d, want %d", path, status, want)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if s := sparkline([]float64{3}, 100, 20); s != "" {
+		t.Errorf("Expected no line for one value, got %q", s)
+	}
+	if s := sparkline([]float64{3, 3}, 100, 20); s != "0.0,10.0 100.0,10.0" {
+		t.Errorf("Got %q for a flat line", s)
+	}
+}
@@ -2,24 +2,55 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zpstore"
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
 	"github.com/spf13/cobra"
 
 	"cloud.google.com/go/storage"
 )
 
+// bucketName is the storage bucket that results go to when running as a service
+const bucketName = "revo-rider-aardvark"
+
 var (
 	Filename         string
 	SpreadsheetID    string
 	SpreadsheetSheet string
 	Limit            int
+	BaseURL          string
+	UserAgent        string
+	Workers          int
+	RequestsPerSec   float64
+	Username         string
+	Password         string
+	SessionFile      string
+	Windows          []zp.Window
+	AsOf             time.Time
+	Lang             zp.Language
+	Schema           = zp.DefaultSchema(nil, zp.English)
+	Format           string
+	ParquetDir       string
+	DB               string
+	StateDir         string
+	RefreshAfter     time.Duration
+	CacheDir         string
+	TeamTTL          time.Duration
+	ProfileTTL       time.Duration
 	storageClient    *storage.Client
 )
 
@@ -36,7 +67,105 @@ func getID(args []string, defaultID int) (id int) {
 	return id
 }
 
+// newClient gets a ZwiftPower client configured from the command line
+func newClient() (*zp.Client, error) {
+	client, err := zp.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if BaseURL != "" {
+		client.BaseURL = BaseURL
+	}
+	client.UserAgent = UserAgent
+	client.RequestsPerSecond = RequestsPerSec
+	client.Windows = Windows
+	client.AsOf = AsOf
+	client.Cache = newCache()
+
+	// Log in if we've been given credentials, otherwise carry on with a saved session if there is one
+	if Username != "" {
+		password := Password
+		if password == "" {
+			password = os.Getenv("ZP_PASSWORD")
+		}
+		if err := client.Login(Username, password); err != nil {
+			return nil, err
+		}
+		if SessionFile != "" {
+			if err := client.SaveSession(SessionFile); err != nil {
+				log.Printf("saving session: %v", err)
+			}
+		}
+	} else if SessionFile != "" {
+		if _, err := os.Stat(SessionFile); err == nil {
+			if err := client.LoadSession(SessionFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return client, nil
+}
+
+// parseAsOf reads a date or an RFC 3339 time. A date means the end of that day, so its events are included.
+// Empty means now, which is the zero time.
+func parseAsOf(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse as-of time %q: use 2006-01-02 or RFC 3339", s)
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// envErr is the first environment variable that couldn't be read, which is reported once the flags have been
+var envErr error
+
+// envDuration reads a duration such as 12h from the environment variable, or gives def if it isn't set. If it
+// isn't a duration, that's saved in envErr.
+func envDuration(name string, def time.Duration) time.Duration {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		if envErr == nil {
+			envErr = fmt.Errorf("bad %s %q: %v", name, s, err)
+		}
+		return def
+	}
+	return d
+}
+
+// readColumnsFile reads column names from a file, one per line or comma-separated. Lines starting # are comments.
+func readColumnsFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("reading columns file: %v", err)
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return strings.Join(names, ","), nil
+}
+
 func main() {
+	var resultsDir, resultsToken, resultsAudience string
 	httpCmd := &cobra.Command{
 		Use:   "http",
 		Short: "Run as a service",
@@ -57,8 +186,16 @@ func main() {
 				port = "8080"
 			}
 
-			http.Handle("/", http.FileServer(http.Dir("/tmp")))
+			if resultsDir != "" {
+				http.Handle("/", newResultsBrowser(resultsDir, resultsToken, resultsAudience))
+			}
 			http.HandleFunc("/trigger", HelloZP)
+			http.HandleFunc("/members", MembersHandler)
+			http.HandleFunc("/clubs/", APIHandler)
+			http.HandleFunc("/riders/", APIHandler)
+			http.HandleFunc("/leaderboard/", LeaderboardHandler)
+			http.Handle("/jobs", jobs)
+			http.Handle("/jobs/", jobs)
 
 			// Start HTTP server.
 			log.Printf("Listening on port %s", port)
@@ -67,36 +204,136 @@ func main() {
 			}
 		},
 	}
+	httpCmd.Flags().StringVar(&resultsDir, "results-dir", os.Getenv("RESULTS_DIR"), "Directory of reports to browse at /. Nothing is served there if it isn't set.")
+	httpCmd.Flags().StringVar(&resultsToken, "results-token", os.Getenv("RESULTS_TOKEN"), "Shared token needed to browse the reports")
+	httpCmd.Flags().StringVar(&resultsAudience, "results-audience", os.Getenv("RESULTS_AUDIENCE"), "Audience of Google ID tokens that may browse the reports, e.g. the service URL")
 
 	riderCmd := &cobra.Command{
 		Use:   "rider [ID]",
 		Short: "Import data for rider ID",
 		Run: func(cmd *cobra.Command, args []string) {
 			riderID := getID(args, 98588)
-			client, err := zp.NewClient()
+			client, err := newClient()
 			if err != nil {
-				fmt.Printf("Error getting client: %v", err)
+				fmt.Fprintf(os.Stderr, "Error getting client: %v\n", err)
+				os.Exit(1)
 			}
 
 			rider, err := zp.ImportRider(client, riderID)
 			if err != nil {
-				fmt.Printf("Error getting rider: %v", err)
+				fmt.Fprintf(os.Stderr, "Error getting rider %d: %v\n", riderID, err)
+				os.Exit(1)
+			}
+			if Format == FormatJSON || Format == FormatNDJSON {
+				data, _ := json.Marshal(Schema.Record(rider))
+				fmt.Printf("%s\n", data)
+				return
+			}
+			fmt.Printf("%v\n", Schema.Row(rider))
+		},
+	}
+
+	var recordDir string
+	recordCmd := &cobra.Command{
+		Use:   "record [ID]",
+		Short: "Record ZwiftPower responses for club ID as test fixtures",
+		Run: func(cmd *cobra.Command, args []string) {
+			clubID := getID(args, 2740)
+			err := Record(clubID, Limit, recordDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error recording ZwiftPower data for %d: %v", clubID, err)
+				os.Exit(1)
+			}
+		},
+	}
+	recordCmd.Flags().StringVarP(&recordDir, "dir", "d", "testdata", "Directory to record fixtures into")
+
+	historyCmd := &cobra.Command{
+		Use:   "history [ZWID]",
+		Short: "Show rider ZWID's stats from each run saved in the database",
+		Run: func(cmd *cobra.Command, args []string) {
+			zwid := getID(args, 98588)
+			if DB == "" {
+				fmt.Fprintln(os.Stderr, "Need a database: use --db or ZP_DB")
+				os.Exit(1)
+			}
+			if err := History(zwid, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error getting history for %d: %v", zwid, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	var diffClub int
+	diffOpts := zp.DefaultDiffOptions
+	diffCmd := &cobra.Command{
+		Use:   "diff [OLD NEW]",
+		Short: "Report riders who joined, left, changed FTP or became inactive between two snapshots",
+		Long: `OLD and NEW are run IDs in the database (--db), or CSV results files.
+With no arguments, the club's two most recent runs in the database are compared.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 && len(args) != 2 {
+				return fmt.Errorf("need two snapshots to compare, or none to use the latest runs")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var oldName, newName string
+			if len(args) == 2 {
+				oldName, newName = args[0], args[1]
+			}
+			if err := DiffSnapshots(diffClub, oldName, newName, diffOpts, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error comparing snapshots: %v", err)
+				os.Exit(1)
 			}
-			fmt.Printf("%v\n", rider.Strings())
 		},
 	}
+	diffCmd.Flags().IntVar(&diffClub, "club", 2740, "Club whose latest runs to compare, if no snapshots are given")
+	diffCmd.Flags().Float64Var(&diffOpts.FtpThreshold, "ftp-threshold", diffOpts.FtpThreshold, "Report FTP changes bigger than this, in W/kg")
+	diffCmd.Flags().IntVar(&diffOpts.InactiveDays, "inactive-days", diffOpts.InactiveDays, "Riders with no event in this many days are inactive")
 
+	var windows, asOf, lang, columns, columnsFile string
 	rootCmd := &cobra.Command{
 		Use:   "zp [ID]",
 		Short: "Import data for club ID",
 		Long:  `Default club ID is 2740, Team CRYO-GEN`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if envErr != nil {
+				return envErr
+			}
+			var err error
+			Windows, err = zp.ParseWindows(windows)
+			if err != nil {
+				return err
+			}
+			AsOf, err = parseAsOf(asOf)
+			if err != nil {
+				return err
+			}
+			Lang, err = zp.ParseLanguage(lang)
+			if err != nil {
+				return err
+			}
+			if columnsFile != "" {
+				columns, err = readColumnsFile(columnsFile)
+				if err != nil {
+					return err
+				}
+			}
+			Schema, err = zp.ParseSchema(columns, Windows, Lang)
+			if err != nil {
+				return err
+			}
+			return flagOptions(0).check()
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			clubID := getID(args, 2740)
-			err := ZwiftPower(clubID, Limit)
+			summary, err := ZwiftPower(flagOptions(clubID))
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error getting ZwiftPower data for %d: %v", clubID, err)
 				os.Exit(1)
 			}
+			fmt.Fprint(os.Stderr, summary)
 		},
 	}
 
@@ -106,21 +343,100 @@ func main() {
 		limit, _ = strconv.Atoi(limitString)
 	}
 
+	workers := 4
+	workersString := os.Getenv("WORKERS")
+	if workersString != "" {
+		workers, _ = strconv.Atoi(workersString)
+	}
+
+	var rps float64
+	rpsString := os.Getenv("RPS")
+	if rpsString != "" {
+		rps, _ = strconv.ParseFloat(rpsString, 64)
+	}
+
 	rootCmd.PersistentFlags().StringVarP(&Filename, "filename", "f", os.Getenv("FILENAME"), "Output file name")
 	rootCmd.PersistentFlags().StringVarP(&SpreadsheetID, "spreadsheet", "s", os.Getenv("SPREADSHEET_ID"), "Google sheets ID")
 	rootCmd.PersistentFlags().StringVarP(&SpreadsheetSheet, "sheetname", "n", os.Getenv("SPREADSHEET_SHEET"), "Google sheets sheet name")
 	rootCmd.PersistentFlags().IntVarP(&Limit, "limit", "l", limit, "Restrict to retrieving this number of riders' data. 0 means no limit - get them all.")
+	rootCmd.PersistentFlags().IntVarP(&Workers, "workers", "w", workers, "Number of riders to import concurrently")
+	rootCmd.PersistentFlags().Float64Var(&RequestsPerSec, "rps", rps, "Limit on requests per second to ZwiftPower. 0 means no limit.")
+	rootCmd.PersistentFlags().StringVar(&windows, "windows", os.Getenv("WINDOWS"), "Extra periods for stats, such as 7d,28d,month,year,season:2026-09-01. Each adds FTP and race count columns.")
+	rootCmd.PersistentFlags().StringVar(&asOf, "as-of", os.Getenv("AS_OF"), "Work out stats as of this date (2006-01-02) or time (RFC 3339) instead of now, to regenerate an old report")
+	rootCmd.PersistentFlags().StringVar(&lang, "lang", os.Getenv("ZP_LANG"), "Language for words in the output: en, nl or de")
+	rootCmd.PersistentFlags().StringVar(&columns, "columns", os.Getenv("ZP_COLUMNS"), "Comma-separated output columns, such as name,zwid,ftp30,ftp_28d. Default is the standard set.")
+	rootCmd.PersistentFlags().StringVar(&columnsFile, "columns-file", os.Getenv("ZP_COLUMNS_FILE"), "File listing the output columns, one per line")
+	rootCmd.PersistentFlags().StringVar(&Format, "format", os.Getenv("FORMAT"), "Output format: csv (the default), json or ndjson. Spreadsheets are always tables.")
+	rootCmd.PersistentFlags().StringVar(&ParquetDir, "parquet-dir", os.Getenv("PARQUET_DIR"), "Also export riders and their events as Parquet files under this directory (or bucket prefix), partitioned by run date")
+	rootCmd.PersistentFlags().StringVar(&DB, "db", os.Getenv("ZP_DB"), "SQLite database to record each run, rider snapshots and events in")
+	rootCmd.PersistentFlags().StringVar(&StateDir, "state-dir", os.Getenv("STATE_DIR"), "Directory to remember riders' data in between runs, so riders whose club list entry hasn't changed aren't fetched again")
+	rootCmd.PersistentFlags().DurationVar(&RefreshAfter, "refresh-after", envDuration("REFRESH_AFTER", 0), "With --state-dir, fetch unchanged riders' data anyway once it's this old, e.g. 168h. 0 means never.")
+	rootCmd.PersistentFlags().StringVar(&CacheDir, "cache-dir", os.Getenv("CACHE_DIR"), "Cache ZwiftPower responses under this directory (or bucket prefix)")
+	rootCmd.PersistentFlags().DurationVar(&TeamTTL, "team-ttl", envDuration("TEAM_TTL", time.Hour), "With --cache-dir, how long to keep a club's rider list. 0 means don't cache it.")
+	rootCmd.PersistentFlags().DurationVar(&ProfileTTL, "profile-ttl", envDuration("PROFILE_TTL", 12*time.Hour), "With --cache-dir, how long to keep a rider's data. 0 means don't cache it.")
+	rootCmd.PersistentFlags().StringVar(&BaseURL, "base-url", os.Getenv("ZP_BASE_URL"), "ZwiftPower base URL, e.g. to use a local fixture server")
+	rootCmd.PersistentFlags().StringVar(&UserAgent, "user-agent", os.Getenv("ZP_USER_AGENT"), "User agent for requests to ZwiftPower")
+	rootCmd.PersistentFlags().StringVar(&Username, "username", os.Getenv("ZP_USERNAME"), "ZwiftPower (Zwift) username, for pages that need you to be logged in")
+	rootCmd.PersistentFlags().StringVar(&Password, "password", "", "ZwiftPower (Zwift) password. Better set in ZP_PASSWORD, so it isn't seen in the process list.")
+	rootCmd.PersistentFlags().StringVar(&SessionFile, "session-file", os.Getenv("ZP_SESSION_FILE"), "File to save the ZwiftPower login session in, and reuse it from")
 	rootCmd.AddCommand(httpCmd)
 	rootCmd.AddCommand(riderCmd)
+	rootCmd.AddCommand(recordCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(diffCmd)
 	rootCmd.Execute()
 }
 
-func setOutput(filename string) (io.WriteCloser, error) {
+// Options say which club to import and where to write the results
+type Options struct {
+	ClubID int
+	// Limit restricts how many riders are imported. Zero means all of them
+	Limit  int
+	Format string
+	// SpreadsheetID and SpreadsheetSheet are the Google sheet to write to, if there is one
+	SpreadsheetID    string
+	SpreadsheetSheet string
+	// Filename is the file to write to, or the object in the storage bucket when running as a service.
+	// Empty means stdout, or results.<format> in the bucket.
+	Filename string
+}
+
+// flagOptions are the options for the club from the command line or environment
+func flagOptions(clubID int) Options {
+	return Options{
+		ClubID:           clubID,
+		Limit:            Limit,
+		Format:           Format,
+		SpreadsheetID:    SpreadsheetID,
+		SpreadsheetSheet: SpreadsheetSheet,
+		Filename:         Filename,
+	}
+}
+
+// check makes sure the options make sense together
+func (o Options) check() error {
+	switch o.Format {
+	case "", FormatCSV:
+	case FormatJSON, FormatNDJSON:
+		if o.SpreadsheetID != "" {
+			return fmt.Errorf("can't write %s to a spreadsheet", o.Format)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", o.Format)
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("limit can't be negative")
+	}
+	return nil
+}
+
+func setOutput(opts Options) (io.WriteCloser, error) {
 	ctx := context.Background()
+	filename := opts.Filename
 
-	if SpreadsheetID != "" {
+	if opts.SpreadsheetID != "" {
 		log.Printf("Writing to spreadsheet")
-		sw, err := NewSpreadsheetWriter(ctx, SpreadsheetID, SpreadsheetSheet)
+		sw, err := NewSpreadsheetWriter(ctx, opts.SpreadsheetID, opts.SpreadsheetSheet, len(Schema.Columns))
 		if err != nil {
 			return nil, fmt.Errorf("error getting spreadsheet client: %v", err)
 		}
@@ -129,17 +445,10 @@ func setOutput(filename string) (io.WriteCloser, error) {
 
 	// Upload an object with storage.Writer.
 	if storageClient != nil {
-		log.Printf("Writing to storage bucket")
-		bkt := storageClient.Bucket("revo-rider-aardvark")
-		attrs, err := bkt.Attrs(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("error getting bucket attributes: %v", err)
+		if filename == "" {
+			filename = "results." + outputExtension(opts.Format)
 		}
-
-		log.Printf("bucket %s, created at %s, is located in %s with storage class %s\n",
-			attrs.Name, attrs.Created, attrs.Location, attrs.StorageClass)
-		sc := bkt.Object("results.csv").NewWriter(ctx)
-		return sc, nil
+		return bucketWriter(ctx, filename)
 	}
 
 	if filename == "" {
@@ -156,65 +465,362 @@ func setOutput(filename string) (io.WriteCloser, error) {
 	return f, err
 }
 
-func ZwiftPower(clubID int, limit int) error {
-	client, err := zp.NewClient()
+// setFailureOutput decides where to write the list of riders we couldn't import, alongside the results.
+// It returns nil if there's nowhere suitable.
+func setFailureOutput(opts Options) (io.WriteCloser, error) {
+	filename := opts.Filename
+	if opts.SpreadsheetID != "" {
+		// Don't mess up the sheet; the failures get logged and returned in the summary instead
+		return nil, nil
+	}
+
+	if storageClient != nil {
+		if filename == "" {
+			return bucketWriter(context.Background(), "failures.csv")
+		}
+		return bucketWriter(context.Background(), strings.TrimSuffix(filename, path.Ext(filename))+"-failures.csv")
+	}
+
+	if filename == "" {
+		return nopCloser{os.Stderr}, nil
+	}
+
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + "-failures.csv"
+	log.Printf("Writing failures to file %s", filename)
+	return os.Create(filename)
+}
+
+func bucketWriter(ctx context.Context, object string) (io.WriteCloser, error) {
+	log.Printf("Writing %s to storage bucket", object)
+	bkt := storageClient.Bucket(bucketName)
+	attrs, err := bkt.Attrs(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting client: %v", err)
+		return nil, fmt.Errorf("error getting bucket attributes: %v", err)
+	}
+
+	log.Printf("bucket %s, created at %s, is located in %s with storage class %s\n",
+		attrs.Name, attrs.Created, attrs.Location, attrs.StorageClass)
+	sc := bkt.Object(object).NewWriter(ctx)
+	return sc, nil
+}
+
+// outputExtension is the file extension for the output format
+func outputExtension(format string) string {
+	if format == "" {
+		return FormatCSV
+	}
+	return format
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Summary describes how an import went
+type Summary struct {
+	ClubID   int
+	Imported int
+	Failures []zp.Failure
+	// Members has who joined and left since the last run, if we're keeping track
+	Members *zp.Members
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Imported %d riders for club %d\n", s.Imported, s.ClubID)
+	if len(s.Failures) > 0 {
+		fmt.Fprintf(&b, "Failed to import %d riders:\n", len(s.Failures))
+		for _, f := range s.Failures {
+			fmt.Fprintf(&b, "  %s (%d): %v\n", f.Name, f.Zwid, f.Err)
+		}
+	}
+	if s.Members != nil {
+		writeMembers(&b, "New members", s.Members.Joined)
+		writeMembers(&b, "Departed members", s.Members.Left)
+	}
+	return b.String()
+}
+
+func writeMembers(w io.Writer, title string, members []zp.Member) {
+	if len(members) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s (%d):\n", title, len(members))
+	for _, m := range members {
+		fmt.Fprintf(w, "  %s (%d) %s\n", m.Name, m.Zwid, zp.ProfileURL(m.Zwid))
+	}
+}
+
+func writeFailures(opts Options, failures []zp.Failure) error {
+	f, err := setFailureOutput(opts)
+	if err != nil || f == nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write(zp.FailureHeader)
+	for _, failure := range failures {
+		w.Write(failure.Strings())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ZwiftPower imports data for the club's riders and writes it out. Riders that can't be imported
+// are listed in the summary and written alongside the results, rather than stopping the run.
+func ZwiftPower(opts Options) (Summary, error) {
+	return importClub(opts, nil)
+}
+
+// importClub is ZwiftPower, calling progress (if it's not nil) as each rider is done
+func importClub(opts Options, progress func(done, total int)) (summary Summary, err error) {
+	clubID := opts.ClubID
+	summary = Summary{ClubID: clubID}
+	client, err := newClient()
+	if err != nil {
+		return summary, fmt.Errorf("error getting client: %v", err)
 	}
 
 	riders, err := zp.ImportZP(client, clubID)
 	if err != nil {
-		return fmt.Errorf("error in ImportZP: %v", err)
+		return summary, fmt.Errorf("error in ImportZP: %v", err)
 	}
 
-	f, err := setOutput(Filename)
+	summary.Members, err = trackMembers(clubID, riders)
 	if err != nil {
-		return fmt.Errorf("opening file %s: %v", Filename, err)
+		log.Printf("tracking members: %v", err)
 	}
+
+	f, err := setOutput(opts)
+	if err != nil {
+		return summary, fmt.Errorf("opening file %s: %v", opts.Filename, err)
+	}
+	// Writes to the bucket can fail when they're closed, and then the results haven't been saved
 	defer func() {
-		err := f.Close()
-		if err != nil {
-			log.Printf("closing: %v", err)
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("closing output: %v", cerr)
 		}
 	}()
 
-	writer := NewRowWriter(f)
+	writer, err := NewResultWriter(f, opts.Format, Schema)
+	if err != nil {
+		return summary, err
+	}
 	defer func() {
 		log.Printf("About to flush")
-		writer.Flush()
+		if ferr := writer.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("writing output: %v", ferr)
+		}
 	}()
 
-	for i, rider := range riders {
-		var err error
-		name := rider.Name
-		riders[i], err = zp.ImportRider(client, rider.Zwid)
+	if opts.Limit > 0 && len(riders) > opts.Limit {
+		log.Printf("Limiting output to %d riders", opts.Limit)
+		riders = riders[:opts.Limit]
+	}
+
+	var px *parquetExporter
+	if ParquetDir != "" {
+		runDate := AsOf
+		if runDate.IsZero() {
+			runDate = time.Now()
+		}
+		px, err = newParquetExporter(ParquetDir, clubID, runDate)
 		if err != nil {
-			return fmt.Errorf("loading data for %s (%d): %v", name, rider.Zwid, err)
+			return summary, fmt.Errorf("starting Parquet export: %v", err)
 		}
-		riders[i].Name = name
-		// fmt.Printf("%v\n", riders[i])
-		err = writer.WriteRow(riders[i].Strings())
+		defer func() {
+			if err := px.Close(); err != nil {
+				log.Printf("closing Parquet export: %v", err)
+			}
+		}()
+	}
+
+	var store *zpstore.Store
+	var run zpstore.Run
+	if DB != "" {
+		store, err = zpstore.Open(DB)
+		if err != nil {
+			return summary, err
+		}
+		defer store.Close()
+
+		run, err = store.StartRun(clubID, AsOf)
+		if err != nil {
+			return summary, err
+		}
+		defer func() {
+			if err := store.FinishRun(run.ID, summary.Imported, len(summary.Failures)); err != nil {
+				log.Printf("recording end of run: %v", err)
+			}
+		}()
+	}
+
+	importer := zp.Importer{
+		Client:       client,
+		Workers:      Workers,
+		RefreshAfter: RefreshAfter,
+	}
+	if StateDir != "" {
+		importer.State, err = zp.OpenClubState(StateDir, clubID)
+		if err != nil {
+			return summary, err
+		}
+		defer func() {
+			if err := importer.State.Save(); err != nil {
+				log.Printf("saving import state: %v", err)
+			}
+		}()
+	}
+
+	var profiles []zp.RiderProfile
+	if progress != nil {
+		progress(0, len(riders))
+	}
+	err = importer.ImportRiders(riders, func(i int, profile zp.RiderProfile, err error) error {
+		if progress != nil {
+			defer progress(i+1, len(riders))
+		}
+		rider := profile.Rider
+		if err != nil {
+			log.Printf("Failed loading data for %s (%d): %v", rider.Name, rider.Zwid, err)
+			summary.Failures = append(summary.Failures, zp.Failure{Name: rider.Name, Zwid: rider.Zwid, Err: err})
+			return nil
+		}
+
+		for _, w := range rider.Warnings {
+			log.Printf("Warning for %s (%d): %s", rider.Name, rider.Zwid, w)
+		}
+
+		err = writer.WriteRider(rider)
 		if err != nil {
 			return fmt.Errorf("writing to file: %v", err)
 		}
+		if px != nil {
+			if err := px.Add(profile); err != nil {
+				return err
+			}
+		}
+		if store != nil {
+			if err := store.SaveProfile(run.ID, profile); err != nil {
+				return err
+			}
+		}
+		profiles = append(profiles, profile)
+		summary.Imported++
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+	latest.set(clubID, profiles, time.Now())
+
+	if err := writeFailures(opts, summary.Failures); err != nil {
+		log.Printf("writing failures: %v", err)
+	}
+
+	return summary, nil
+}
 
-		if limit > 0 && i >= (limit-1) {
-			log.Printf("Limiting output to %d riders", limit)
+// Record saves the club and rider data for clubID into dir, so it can be replayed with zptest
+func Record(clubID int, limit int, dir string) error {
+	client, err := newClient()
+	if err != nil {
+		return fmt.Errorf("error getting client: %v", err)
+	}
+	client.Transport = &zptest.Recorder{Dir: dir, Transport: client.Transport}
+
+	riders, err := zp.ImportZP(client, clubID)
+	if err != nil {
+		return fmt.Errorf("error in ImportZP: %v", err)
+	}
+
+	for i, rider := range riders {
+		if limit > 0 && i >= limit {
+			log.Printf("Limiting recording to %d riders", limit)
 			break
 		}
+
+		_, err := zp.ImportRider(client, rider.Zwid)
+		if err != nil {
+			return fmt.Errorf("loading data for %s (%d): %v", rider.Name, rider.Zwid, err)
+		}
 	}
 
 	return nil
 }
 
+// History writes a CSV of the rider's stats from each run recorded in the database
+func History(zwid int, w io.Writer) error {
+	store, err := zpstore.Open(DB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	history, err := store.History(zwid)
+	if err != nil {
+		return fmt.Errorf("reading history for %d: %v", zwid, err)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"run", "club", "as_of", "name", "ftp30", "ftp60", "ftp90", "races30", "races90", "rides", "races"})
+	for _, h := range history {
+		r := h.Rider
+		cw.Write([]string{
+			strconv.FormatInt(h.Run.ID, 10),
+			strconv.Itoa(h.Run.ClubID),
+			h.Run.AsOf.Format(time.RFC3339),
+			r.Name,
+			fmt.Sprintf("%.1f", r.Ftp30),
+			fmt.Sprintf("%.1f", r.Ftp60),
+			fmt.Sprintf("%.1f", r.Ftp90),
+			strconv.Itoa(r.Races30),
+			strconv.Itoa(r.Races90),
+			strconv.Itoa(r.Rides),
+			strconv.Itoa(r.Races),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// HelloZP runs an import for the club and destination given in the request (see triggerOptions), and responds
+// with a summary of how it went
 func HelloZP(w http.ResponseWriter, r *http.Request) {
-	clubID := 2672
-	err := ZwiftPower(clubID, Limit)
+	opts, status, err := triggerOptions(r)
+	if err != nil {
+		log.Printf("Bad trigger request: %v", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Run it as a job, so it can't run at the same time as another import of the club
+	job, _, err := jobs.start(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting ZwiftPower data for %d: %v", clubID, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	job = jobs.wait(r.Context(), job)
+
+	clubID := opts.ClubID
+	switch job.Status {
+	case JobFailed:
+		fmt.Fprintf(os.Stderr, "Error getting ZwiftPower data for %d: %v", clubID, job.Error)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("%v", err)))
+		w.Write([]byte(job.Error))
+		return
+	case JobRunning:
+		return
 	}
 
 	fmt.Fprintf(w, "Reading data for %d\n", clubID)
+	fmt.Fprint(w, job.summary)
 }
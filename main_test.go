@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+)
+
+func TestZwiftPowerFromFixtures(t *testing.T) {
+	srv := zptest.NewServer("zp/testdata")
+	defer srv.Close()
+
+	BaseURL = srv.URL
+	Filename = filepath.Join(t.TempDir(), "results.csv")
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+	}()
+
+	summary, err := ZwiftPower(flagOptions(2672))
+	if err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+	if summary.Imported != 2 || len(summary.Failures) != 0 {
+		t.Errorf("Unexpected summary %v", summary)
+	}
+
+	f, err := os.Open(Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"Name", "Zwid"},
+		{"&Ouml;zge Yazar [REVO]", "1261784"},
+		{"Liz Rice", "98588"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Got %d rows, expected %d", len(rows), len(expected))
+	}
+	for i, e := range expected {
+		if rows[i][0] != e[0] || rows[i][1] != e[1] {
+			t.Errorf("Row %d: got %v expected to start with %v", i, rows[i], e)
+		}
+	}
+}
+
+func TestZwiftPowerPartialFailure(t *testing.T) {
+	fixtures := zptest.Handler("zp/testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cache3/profile/1261784_all.json" {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	BaseURL = srv.URL
+	Filename = filepath.Join(t.TempDir(), "results.csv")
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+	}()
+
+	summary, err := ZwiftPower(flagOptions(2672))
+	if err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+	if summary.Imported != 1 || len(summary.Failures) != 1 || summary.Failures[0].Zwid != 1261784 {
+		t.Fatalf("Unexpected summary %v", summary)
+	}
+
+	data, err := ioutil.ReadFile(strings.TrimSuffix(Filename, ".csv") + "-failures.csv")
+	if err != nil {
+		t.Fatalf("Reading failures: %v", err)
+	}
+	if !strings.Contains(string(data), "1261784") {
+		t.Errorf("Failures file doesn't mention the rider: %s", data)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	cases := map[int]string{1: "A", 14: "N", 26: "Z", 27: "AA", 52: "AZ", 53: "BA", 702: "ZZ", 703: "AAA"}
+	for col, expected := range cases {
+		if got := columnName(col); got != expected {
+			t.Errorf("Column %d: got %s expected %s", col, got, expected)
+		}
+	}
+}
+
+func TestZwiftPowerJSON(t *testing.T) {
+	srv := zptest.NewServer("zp/testdata")
+	defer srv.Close()
+
+	BaseURL = srv.URL
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+		Format = ""
+	}()
+
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		Format = format
+		Filename = filepath.Join(t.TempDir(), "results."+format)
+		if _, err := ZwiftPower(flagOptions(2672)); err != nil {
+			t.Fatalf("ZwiftPower: %v", err)
+		}
+
+		data, err := ioutil.ReadFile(Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type record struct {
+			Name            string     `json:"name"`
+			Zwid            int        `json:"zwid"`
+			Ftp30           float64    `json:"ftp30"`
+			LatestEventDate *time.Time `json:"latest_event_date"`
+			LastSeen        struct {
+				Bucket string `json:"bucket"`
+			} `json:"last_seen"`
+		}
+
+		var records []record
+		if format == FormatJSON {
+			err = json.Unmarshal(data, &records)
+		} else {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			for dec.More() {
+				var r record
+				if err = dec.Decode(&r); err != nil {
+					break
+				}
+				records = append(records, r)
+			}
+		}
+		if err != nil {
+			t.Fatalf("%s: %v in %s", format, err, data)
+		}
+
+		if len(records) != 2 || records[1].Name != "Liz Rice" || records[1].Zwid != 98588 {
+			t.Fatalf("%s: unexpected records %+v", format, records)
+		}
+		if records[1].LatestEventDate == nil || records[1].LatestEventDate.Unix() != 1586959200 {
+			t.Errorf("%s: unexpected latest event date %v", format, records[1].LatestEventDate)
+		}
+		if records[1].LastSeen.Bucket != "over_a_year" {
+			t.Errorf("%s: unexpected recency %+v", format, records[1].LastSeen)
+		}
+	}
+}
+
+// failingWriter fails every write, like a bucket upload that's gone wrong
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("upload failed") }
+
+func TestResultWriterErrors(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatJSON, FormatNDJSON} {
+		w, err := NewResultWriter(failingWriter{}, format, Schema)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		w.WriteRider(zp.Rider{Name: "Liz Rice", Zwid: 98588})
+		if err := w.Flush(); err == nil {
+			t.Errorf("%s: expected an error from Flush", format)
+		}
+	}
+}
+
+func TestZwiftPowerHistory(t *testing.T) {
+	srv := zptest.NewServer("zp/testdata")
+	defer srv.Close()
+
+	dir := t.TempDir()
+	BaseURL = srv.URL
+	Filename = filepath.Join(dir, "results.csv")
+	DB = filepath.Join(dir, "history.db")
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+		DB = ""
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, err := ZwiftPower(flagOptions(2672)); err != nil {
+			t.Fatalf("ZwiftPower: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := History(98588, &buf); err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Got %d rows, want header and one per run: %v", len(rows), rows)
+	}
+	if rows[1][0] != "1" || rows[2][0] != "2" || rows[2][3] != "Liz Rice" {
+		t.Errorf("Unexpected history %v", rows)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.csv")
+	new := filepath.Join(dir, "new.csv")
+	ioutil.WriteFile(old, []byte("Name,Zwid,FTP 30 days\nLiz Rice,98588,2.5\nGone,1,3.0\n"), 0644)
+	ioutil.WriteFile(new, []byte("Name,Zwid,FTP 30 days\nLiz Rice,98588,2.9\nNew,2,3.0\n"), 0644)
+
+	var buf bytes.Buffer
+	if err := DiffSnapshots(2672, old, new, zp.DefaultDiffOptions, &buf); err != nil {
+		t.Fatalf("DiffSnapshots: %v", err)
+	}
+
+	expected := "Change,Name,Zwid,Old,New\njoined,New,2,,\nleft,Gone,1,,\nftp30,Liz Rice,98588,2.5,2.9\n"
+	if buf.String() != expected {
+		t.Errorf("Got\n%s\nwant\n%s", buf.String(), expected)
+	}
+
+	if err := DiffSnapshots(2672, "", "", zp.DefaultDiffOptions, &buf); err == nil {
+		t.Errorf("Expected error with no snapshots or database")
+	}
+}
+
+func TestZwiftPowerMembers(t *testing.T) {
+	team := `{"data":[{"zwid":98588,"name":"Liz Rice"}]}`
+	fixtures := zptest.Handler("zp/testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cache3/teams/2672_riders.json" {
+			w.Write([]byte(team))
+			return
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	BaseURL = srv.URL
+	Filename = filepath.Join(dir, "results.csv")
+	StateDir = dir
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+		StateDir = ""
+	}()
+
+	summary, err := ZwiftPower(flagOptions(2672))
+	if err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+	if summary.Members == nil || len(summary.Members.Joined) != 0 {
+		t.Errorf("First run shouldn't have new members: %v", summary)
+	}
+
+	team = `{"data":[{"zwid":1261784,"name":"&Ouml;zge Yazar [REVO]"}]}`
+	summary, err = ZwiftPower(flagOptions(2672))
+	if err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+	s := summary.String()
+	if !strings.Contains(s, "New members (1):\n  &Ouml;zge Yazar [REVO] (1261784)") ||
+		!strings.Contains(s, "Departed members (1):\n  Liz Rice (98588)") {
+		t.Errorf("Unexpected summary\n%s", s)
+	}
+
+	rec := httptest.NewRecorder()
+	MembersHandler(rec, httptest.NewRequest(http.MethodGet, "/members?club=2672", nil))
+	var m struct {
+		Joined []struct{ Zwid int }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil || len(m.Joined) != 1 || m.Joined[0].Zwid != 1261784 {
+		t.Errorf("Unexpected /members response %s", rec.Body.String())
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	defer func() { envErr = nil }()
+	os.Setenv("TEST_TTL", "2h")
+	defer os.Unsetenv("TEST_TTL")
+
+	if d := envDuration("TEST_TTL", time.Hour); d != 2*time.Hour || envErr != nil {
+		t.Errorf("Got %v, %v", d, envErr)
+	}
+	if d := envDuration("TEST_UNSET_TTL", time.Hour); d != time.Hour || envErr != nil {
+		t.Errorf("Got %v, %v for an unset variable", d, envErr)
+	}
+
+	os.Setenv("TEST_TTL", "2 hours")
+	if d := envDuration("TEST_TTL", time.Hour); d != time.Hour || envErr == nil {
+		t.Errorf("Got %v, %v for a bad duration", d, envErr)
+	}
+}
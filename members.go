@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hermannatorii/zwiftpower/zp"
+)
+
+// membersFile is where the club's member list is kept between runs: in the storage bucket if we're using one,
+// otherwise in StateDir. It's "" if there's nowhere to keep it.
+func membersFile(clubID int) string {
+	name := fmt.Sprintf("club_%d.json", clubID)
+	switch {
+	case storageClient != nil:
+		return path.Join("members", name)
+	case StateDir != "":
+		return filepath.Join(StateDir, "members", name)
+	}
+	return ""
+}
+
+// loadMembers reads the member list saved by the last run, or nil if there isn't one
+func loadMembers(clubID int) (*zp.Members, error) {
+	name := membersFile(clubID)
+	if name == "" {
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+	if storageClient != nil {
+		var r *storage.Reader
+		r, err = storageClient.Bucket(bucketName).Object(name).NewReader(context.Background())
+		if err == storage.ErrObjectNotExist {
+			return nil, nil
+		}
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+	} else {
+		data, err = ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading member list %s: %v", name, err)
+	}
+
+	var m zp.Members
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing member list %s: %v", name, err)
+	}
+	return &m, nil
+}
+
+// saveMembers keeps the member list for the next run
+func saveMembers(m zp.Members) error {
+	name := membersFile(m.ClubID)
+	if name == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if storageClient != nil {
+		w, err := bucketWriter(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0644)
+}
+
+// trackMembers compares the club's riders with the last run's, and saves them for next time
+func trackMembers(clubID int, riders []zp.Rider) (*zp.Members, error) {
+	if membersFile(clubID) == "" {
+		return nil, nil
+	}
+
+	prev, err := loadMembers(clubID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := zp.TrackMembers(prev, clubID, riders, time.Now())
+	if err := saveMembers(m); err != nil {
+		return nil, fmt.Errorf("saving member list: %v", err)
+	}
+	return &m, nil
+}
+
+// MembersHandler serves the member list from the latest run, with who joined and left, for ?club=ID
+func MembersHandler(w http.ResponseWriter, r *http.Request) {
+	clubID := triggerClubID
+	if s := r.URL.Query().Get("club"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "bad club ID", http.StatusBadRequest)
+			return
+		}
+		clubID = id
+	}
+
+	m, err := loadMembers(clubID)
+	if err != nil {
+		log.Printf("Loading members for %d: %v", clubID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+)
+
+// Output formats
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// resultWriter writes out riders in one of the output formats
+type resultWriter interface {
+	WriteRider(r zp.Rider) error
+	// Flush writes out anything buffered, and gives any error from writing
+	Flush() error
+}
+
+// NewResultWriter gets a writer for the format. Tables (CSV or a spreadsheet) start with a header row from the
+// schema; JSON and NDJSON have a structured record per rider, with the schema's columns as keys.
+func NewResultWriter(w io.Writer, format string, schema zp.Schema) (resultWriter, error) {
+	switch format {
+	case "", FormatCSV:
+		rw := NewRowWriter(w)
+		if err := rw.WriteRow(schema.Header()); err != nil {
+			return nil, fmt.Errorf("writing header: %v", err)
+		}
+		return &tableWriter{rw: rw, schema: schema}, nil
+
+	case FormatJSON, FormatNDJSON:
+		if _, ok := w.(*spreadsheetWriter); ok {
+			return nil, fmt.Errorf("can't write %s to a spreadsheet", format)
+		}
+		return &jsonWriter{w: bufio.NewWriter(w), schema: schema, array: format == FormatJSON}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+type tableWriter struct {
+	rw     rowWriter
+	schema zp.Schema
+}
+
+func (t *tableWriter) WriteRider(r zp.Rider) error {
+	return t.rw.WriteRow(t.schema.Row(r))
+}
+
+func (t *tableWriter) Flush() error {
+	t.rw.Flush()
+	// The CSV writer remembers errors; the spreadsheet writer logs them as it goes
+	if e, ok := t.rw.(interface{ Error() error }); ok {
+		return e.Error()
+	}
+	return nil
+}
+
+// jsonWriter writes a record per line, wrapped in an array for JSON or on its own for NDJSON
+type jsonWriter struct {
+	w      *bufio.Writer
+	schema zp.Schema
+	array  bool
+	count  int
+}
+
+func (j *jsonWriter) WriteRider(r zp.Rider) error {
+	data, err := json.Marshal(j.schema.Record(r))
+	if err != nil {
+		return err
+	}
+
+	sep := ""
+	switch {
+	case !j.array:
+	case j.count == 0:
+		sep = "[\n"
+	default:
+		sep = ",\n"
+	}
+	j.count++
+
+	if _, err := j.w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	if !j.array {
+		return j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// Flush finishes off the output, so it should only be called once everything's written
+func (j *jsonWriter) Flush() error {
+	if j.array {
+		if j.count == 0 {
+			j.w.WriteString("[")
+		}
+		j.w.WriteString("\n]\n")
+	}
+	return j.w.Flush()
+}
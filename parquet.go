@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRider is a row of the riders table: a rider's aggregate stats from one run
+type parquetRider struct {
+	ClubID           int64   `parquet:"name=club_id, type=INT64"`
+	Zwid             int64   `parquet:"name=zwid, type=INT64"`
+	Name             string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AsOf             int64   `parquet:"name=as_of, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	LatestEventDate  *int64  `parquet:"name=latest_event_date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	LatestEvent      string  `parquet:"name=latest_event, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rides            int32   `parquet:"name=rides, type=INT32"`
+	Races            int32   `parquet:"name=races, type=INT32"`
+	Races90          int32   `parquet:"name=races90, type=INT32"`
+	Races30          int32   `parquet:"name=races30, type=INT32"`
+	Ftp90            float64 `parquet:"name=ftp90, type=DOUBLE"`
+	Ftp60            float64 `parquet:"name=ftp60, type=DOUBLE"`
+	Ftp30            float64 `parquet:"name=ftp30, type=DOUBLE"`
+	LatestRace       string  `parquet:"name=latest_race, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LatestRaceDate   *int64  `parquet:"name=latest_race_date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	LatestRaceAvgWkg float64 `parquet:"name=latest_race_avg_wkg, type=DOUBLE"`
+	LatestRaceWkgFtp float64 `parquet:"name=latest_race_wkg_ftp, type=DOUBLE"`
+	Category         string  `parquet:"name=category, type=BYTE_ARRAY, convertedtype=UTF8"`
+	WomenCategory    string  `parquet:"name=women_category, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UpgradeRisk      bool    `parquet:"name=upgrade_risk, type=BOOLEAN"`
+}
+
+// parquetEvent is a row of the events table: one of a rider's events. Values that ZwiftPower didn't supply are null.
+type parquetEvent struct {
+	ClubID          int64    `parquet:"name=club_id, type=INT64"`
+	Zwid            int64    `parquet:"name=zwid, type=INT64"`
+	EventID         string   `parquet:"name=event_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventTitle      string   `parquet:"name=event_title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventType       string   `parquet:"name=event_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventDate       *int64   `parquet:"name=event_date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	IsRace          bool     `parquet:"name=is_race, type=BOOLEAN"`
+	Category        string   `parquet:"name=category, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Position        int32    `parquet:"name=position, type=INT32"`
+	PositionInCat   int32    `parquet:"name=position_in_cat, type=INT32"`
+	Seconds         *float64 `parquet:"name=seconds, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Distance        *float64 `parquet:"name=distance_km, type=DOUBLE, repetitiontype=OPTIONAL"`
+	AvgWkg          *float64 `parquet:"name=avg_wkg, type=DOUBLE, repetitiontype=OPTIONAL"`
+	WkgFtp          *float64 `parquet:"name=wkg_ftp, type=DOUBLE, repetitiontype=OPTIONAL"`
+	AvgPower        *float64 `parquet:"name=avg_power, type=DOUBLE, repetitiontype=OPTIONAL"`
+	NormalizedPower *float64 `parquet:"name=normalized_power, type=DOUBLE, repetitiontype=OPTIONAL"`
+	AvgHR           *float64 `parquet:"name=avg_hr, type=DOUBLE, repetitiontype=OPTIONAL"`
+	MaxHR           *float64 `parquet:"name=max_hr, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Weight          *float64 `parquet:"name=weight, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Height          *float64 `parquet:"name=height, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Watts5          *float64 `parquet:"name=watts5, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Watts60         *float64 `parquet:"name=watts60, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Watts300        *float64 `parquet:"name=watts300, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Watts1200       *float64 `parquet:"name=watts1200, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Wkg5            *float64 `parquet:"name=wkg5, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Wkg60           *float64 `parquet:"name=wkg60, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Wkg300          *float64 `parquet:"name=wkg300, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Wkg1200         *float64 `parquet:"name=wkg1200, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+func millis(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return &ms
+}
+
+func tupleValue(t zp.Tuple) *float64 {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Value
+	return &v
+}
+
+// parquetExporter writes riders' stats and events to Parquet files, partitioned by run date so that a year of
+// runs can be queried together, e.g. with DuckDB's hive_partitioning
+type parquetExporter struct {
+	clubID int64
+	// asOf is when the stats are worked out for. Events after it are left out, as they are from the stats.
+	asOf   time.Time
+	files  []io.WriteCloser
+	riders *writer.ParquetWriter
+	events *writer.ParquetWriter
+}
+
+// newParquetExporter creates riders/run_date=<date>/club_<id>.parquet and the same under events/, either in the
+// storage bucket under prefix, or in the local directory prefix. runDate is the time the stats are as of.
+func newParquetExporter(prefix string, clubID int, runDate time.Time) (*parquetExporter, error) {
+	px := &parquetExporter{clubID: int64(clubID), asOf: runDate}
+	name := func(table string) string {
+		return path.Join(prefix, table, "run_date="+runDate.Format("2006-01-02"), fmt.Sprintf("club_%d.parquet", clubID))
+	}
+
+	var err error
+	px.riders, err = px.create(name("riders"), new(parquetRider))
+	if err != nil {
+		px.abort()
+		return nil, err
+	}
+
+	px.events, err = px.create(name("events"), new(parquetEvent))
+	if err != nil {
+		px.abort()
+		return nil, err
+	}
+
+	return px, nil
+}
+
+func (px *parquetExporter) create(name string, obj interface{}) (*writer.ParquetWriter, error) {
+	var f io.WriteCloser
+	var err error
+	if storageClient != nil {
+		f, err = bucketWriter(context.Background(), name)
+	} else {
+		log.Printf("Writing Parquet file %s", name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return nil, err
+		}
+		f, err = os.Create(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %v", name, err)
+	}
+	px.files = append(px.files, f)
+
+	pw, err := writer.NewParquetWriterFromWriter(f, obj, 1)
+	if err != nil {
+		return nil, fmt.Errorf("creating Parquet writer for %s: %v", name, err)
+	}
+	return pw, nil
+}
+
+// Add writes the rider's stats and all their events up to the run date
+func (px *parquetExporter) Add(p zp.RiderProfile) error {
+	r := p.Rider
+	err := px.riders.Write(parquetRider{
+		ClubID:           px.clubID,
+		Zwid:             int64(r.Zwid),
+		Name:             r.Name,
+		AsOf:             r.AsOf.UnixNano() / int64(time.Millisecond),
+		LatestEventDate:  millis(r.LatestEventDate),
+		LatestEvent:      r.LatestEvent,
+		Rides:            int32(r.Rides),
+		Races:            int32(r.Races),
+		Races90:          int32(r.Races90),
+		Races30:          int32(r.Races30),
+		Ftp90:            r.Ftp90,
+		Ftp60:            r.Ftp60,
+		Ftp30:            r.Ftp30,
+		LatestRace:       r.LatestRace,
+		LatestRaceDate:   millis(r.LatestRaceDate),
+		LatestRaceAvgWkg: r.LatestRaceAvgWkg,
+		LatestRaceWkgFtp: r.LatestRaceWkgFtp,
+		Category:         string(r.Category.Category),
+		WomenCategory:    string(r.Category.WomenCategory),
+		UpgradeRisk:      r.Category.UpgradeRisk,
+	})
+	if err != nil {
+		return fmt.Errorf("writing rider %d to Parquet: %v", r.Zwid, err)
+	}
+
+	for _, e := range p.Events {
+		if e.EventDate.After(px.asOf) {
+			continue
+		}
+		err := px.events.Write(parquetEvent{
+			ClubID:          px.clubID,
+			Zwid:            int64(r.Zwid),
+			EventID:         string(e.EventID),
+			EventTitle:      e.EventTitle,
+			EventType:       e.EventType,
+			EventDate:       millis(e.EventDate),
+			IsRace:          e.IsRace(),
+			Category:        e.Category,
+			Position:        int32(e.Position),
+			PositionInCat:   int32(e.PositionInCat),
+			Seconds:         tupleValue(e.Time),
+			Distance:        tupleValue(e.Distance),
+			AvgWkg:          tupleValue(e.AvgWkg),
+			WkgFtp:          tupleValue(e.WkgFtp),
+			AvgPower:        tupleValue(e.AvgPower),
+			NormalizedPower: tupleValue(e.NormalizedPower),
+			AvgHR:           tupleValue(e.AvgHR),
+			MaxHR:           tupleValue(e.MaxHR),
+			Weight:          tupleValue(e.Weight),
+			Height:          tupleValue(e.Height),
+			Watts5:          tupleValue(e.Watts5),
+			Watts60:         tupleValue(e.Watts60),
+			Watts300:        tupleValue(e.Watts300),
+			Watts1200:       tupleValue(e.Watts1200),
+			Wkg5:            tupleValue(e.Wkg5),
+			Wkg60:           tupleValue(e.Wkg60),
+			Wkg300:          tupleValue(e.Wkg300),
+			Wkg1200:         tupleValue(e.Wkg1200),
+		})
+		if err != nil {
+			return fmt.Errorf("writing event %s for rider %d to Parquet: %v", e.EventID, r.Zwid, err)
+		}
+	}
+
+	return nil
+}
+
+// Close finishes off the Parquet files
+func (px *parquetExporter) Close() error {
+	var firstErr error
+	for _, pw := range []*writer.ParquetWriter{px.riders, px.events} {
+		if err := pw.WriteStop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, f := range px.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// abort closes any files we've opened, after something went wrong
+func (px *parquetExporter) abort() {
+	for _, f := range px.files {
+		f.Close()
+	}
+}
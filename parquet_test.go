@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// memFile lets the Parquet reader read from a byte slice
+type memFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (m *memFile) Open(name string) (source.ParquetFile, error) {
+	return &memFile{bytes.NewReader(m.data), m.data}, nil
+}
+func (m *memFile) Create(name string) (source.ParquetFile, error) { return nil, nil }
+func (m *memFile) Write(p []byte) (int, error)                    { return 0, nil }
+func (m *memFile) Close() error                                   { return nil }
+
+func readParquet(t *testing.T, filename string, obj interface{}, rows interface{}) int {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetReader(&memFile{bytes.NewReader(data), data}, obj, 1)
+	if err != nil {
+		t.Fatalf("reading %s: %v", filename, err)
+	}
+	defer pr.ReadStop()
+
+	n := int(pr.GetNumRows())
+	if err := pr.Read(rows); err != nil {
+		t.Fatalf("reading rows from %s: %v", filename, err)
+	}
+	return n
+}
+
+func TestParquetExport(t *testing.T) {
+	srv := zptest.NewServer("zp/testdata")
+	defer srv.Close()
+
+	BaseURL = srv.URL
+	Filename = filepath.Join(t.TempDir(), "results.csv")
+	ParquetDir = t.TempDir()
+	AsOf = time.Date(2021, 2, 10, 23, 0, 0, 0, time.UTC)
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+		ParquetDir = ""
+		AsOf = time.Time{}
+	}()
+
+	if _, err := ZwiftPower(flagOptions(2672)); err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+
+	riders := make([]parquetRider, 2)
+	n := readParquet(t, filepath.Join(ParquetDir, "riders", "run_date=2021-02-10", "club_2672.parquet"), new(parquetRider), &riders)
+	if n != 2 || riders[0].Zwid != 1261784 || riders[1].Name != "Liz Rice" || riders[0].Ftp30 != 2.9 || riders[0].ClubID != 2672 {
+		t.Errorf("Unexpected riders %+v", riders)
+	}
+	if riders[0].LatestRaceDate == nil || *riders[0].LatestRaceDate != 1612320300000 {
+		t.Errorf("Unexpected latest race date %v", riders[0].LatestRaceDate)
+	}
+
+	events := make([]parquetEvent, 16)
+	n = readParquet(t, filepath.Join(ParquetDir, "events", "run_date=2021-02-10", "club_2672.parquet"), new(parquetEvent), &events)
+	if n != 16 {
+		t.Fatalf("Got %d events, expected 16", n)
+	}
+
+	// The first event is the undated social ride
+	e := events[0]
+	if e.EventDate != nil || e.EventTitle != "REVO Social SUB2" || e.IsRace || e.Seconds == nil || *e.Seconds != 3600 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if e.Zwid != 1261784 || e.AvgPower == nil || *e.AvgPower != 102 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	// Events after the as-of date aren't exported, as they aren't counted in the stats
+	AsOf = time.Date(2021, 1, 31, 23, 0, 0, 0, time.UTC)
+	if _, err := ZwiftPower(flagOptions(2672)); err != nil {
+		t.Fatalf("ZwiftPower: %v", err)
+	}
+	events = make([]parquetEvent, 16)
+	n = readParquet(t, filepath.Join(ParquetDir, "events", "run_date=2021-01-31", "club_2672.parquet"), new(parquetEvent), &events)
+	if n == 0 || n >= 16 {
+		t.Fatalf("Got %d events as of %s", n, AsOf)
+	}
+	for _, e := range events[:n] {
+		if e.EventDate != nil && *e.EventDate > AsOf.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("Got event %s from after the as-of date", e.EventTitle)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/idtoken"
+)
+
+// resultTypes are the kinds of file the results browser serves, by extension. Anything else isn't served.
+var resultTypes = map[string]string{
+	".csv":     "text/csv; charset=utf-8",
+	".json":    "application/json",
+	".ndjson":  "application/x-ndjson",
+	".html":    "text/html; charset=utf-8",
+	".parquet": "application/octet-stream",
+}
+
+// resultsBrowser serves the reports in a directory, with listings of its subdirectories so that past runs can be
+// found. Hidden files, symlinks and files that aren't reports are never served. If there's a token or an audience,
+// requests need to give either the shared token or a Google-signed ID token for the audience, as a bearer token.
+type resultsBrowser struct {
+	dir      string
+	token    string
+	audience string
+	// validate checks an ID token; it's idtoken.Validate except in tests
+	validate func(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+}
+
+// tokenCookie is the cookie the shared token is kept in, once it's been given in the query
+const tokenCookie = "results_token"
+
+func newResultsBrowser(dir, token, audience string) *resultsBrowser {
+	return &resultsBrowser{dir: dir, token: token, audience: audience, validate: idtoken.Validate}
+}
+
+func (b *resultsBrowser) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !b.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if b.token != "" && r.URL.Query().Get("token") == b.token {
+		// Remember the token, so the links in listings work without it
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    b.token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// Lstat only looks at the last part of the path, so resolve the whole of it: if it isn't where it would be
+	// without symlinks, one of its directories is a symlink
+	root, err := filepath.EvalSymlinks(b.dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	full, err := filepath.EvalSymlinks(filepath.Join(b.dir, filepath.FromSlash(name)))
+	if err != nil || full != filepath.Join(root, filepath.FromSlash(name)) {
+		http.NotFound(w, r)
+		return
+	}
+	fi, err := os.Lstat(full)
+	if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := path.Base(name) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		b.list(w, name, full)
+		return
+	}
+
+	contentType, ok := resultTypes[strings.ToLower(filepath.Ext(full))]
+	if !ok || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// authorized says whether the request has the shared token or a valid ID token, if either is needed
+func (b *resultsBrowser) authorized(r *http.Request) bool {
+	if b.token == "" && b.audience == "" {
+		return true
+	}
+
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if bearer == "" || bearer == r.Header.Get("Authorization") {
+		// Browsers can't easily send headers, so the shared token can go in the query instead, after which
+		// it's kept in a cookie
+		bearer = r.URL.Query().Get("token")
+		if c, err := r.Cookie(tokenCookie); bearer == "" && err == nil {
+			bearer = c.Value
+		}
+	}
+	if bearer == "" {
+		return false
+	}
+
+	if b.token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(b.token)) == 1 {
+		return true
+	}
+	if b.audience != "" {
+		if _, err := b.validate(r.Context(), bearer, b.audience); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resultEntry is a line in a directory listing
+type resultEntry struct {
+	Name     string
+	Dir      bool
+	Size     int64
+	Modified time.Time
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Results {{.Path}}</title></head>
+<body>
+<h1>Results {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Name}}{{if .Dir}}/{{end}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td>{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.Modified.UTC.Format "2006-01-02 15:04"}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// list writes the directory's reports and subdirectories, newest first
+func (b *resultsBrowser) list(w http.ResponseWriter, name, full string) {
+	infos, err := ioutil.ReadDir(full)
+	if err != nil {
+		log.Printf("Listing %s: %v", full, err)
+		http.Error(w, "can't list directory", http.StatusInternalServerError)
+		return
+	}
+
+	entries := []resultEntry{}
+	for _, fi := range infos {
+		_, isResult := resultTypes[strings.ToLower(filepath.Ext(fi.Name()))]
+		switch {
+		case strings.HasPrefix(fi.Name(), "."):
+		case fi.IsDir():
+			entries = append(entries, resultEntry{Name: fi.Name(), Dir: true, Modified: fi.ModTime()})
+		case fi.Mode().IsRegular() && isResult:
+			entries = append(entries, resultEntry{Name: fi.Name(), Size: fi.Size(), Modified: fi.ModTime()})
+		}
+	}
+	sort.SliceStable(entries, func(i, k int) bool {
+		if !entries[i].Modified.Equal(entries[k].Modified) {
+			return entries[i].Modified.After(entries[k].Modified)
+		}
+		return entries[i].Name > entries[k].Name
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []resultEntry
+	}{name, entries})
+	if err != nil {
+		log.Printf("Writing listing of %s: %v", full, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/idtoken"
+)
+
+func TestResultsBrowser(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"results.csv":                   "Name,Zwid\n",
+		"run_date=2026-10-01/club.json": "[]",
+		"report.html":                   "<p>report</p>",
+		"notes.txt":                     "not a report",
+		".state/members.json":           "{}",
+	} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "passwd.csv")); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret.csv"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newResultsBrowser(dir, "", "")
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	get := func(path, auth string) (int, string, string) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/results.csv", http.StatusOK, "text/csv; charset=utf-8"},
+		{"/run_date=2026-10-01/club.json", http.StatusOK, "application/json"},
+		{"/report.html", http.StatusOK, "text/html; charset=utf-8"},
+		{"/run_date=2026-10-01", http.StatusOK, "text/html; charset=utf-8"},
+		{"/notes.txt", http.StatusNotFound, ""},
+		{"/.state/members.json", http.StatusNotFound, ""},
+		{"/passwd.csv", http.StatusNotFound, ""},
+		{"/linked/secret.csv", http.StatusNotFound, ""},
+		{"/linked/", http.StatusNotFound, ""},
+		{"/../../etc/passwd", http.StatusNotFound, ""},
+		{"/missing.csv", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		status, contentType, _ := get(test.path, "")
+		if status != test.status || (test.contentType != "" && contentType != test.contentType) {
+			t.Errorf("%s: got %d %q, want %d %q", test.path, status, contentType, test.status, test.contentType)
+		}
+	}
+
+	_, _, listing := get("/", "")
+	for _, want := range []string{`href="results.csv"`, `href="run_date=2026-10-01/"`, `href="report.html"`} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("Listing doesn't have %s:\n%s", want, listing)
+		}
+	}
+	for _, unwanted := range []string{"notes.txt", ".state", "passwd", "linked"} {
+		if strings.Contains(listing, unwanted) {
+			t.Errorf("Listing shouldn't have %s:\n%s", unwanted, listing)
+		}
+	}
+
+	// With a shared token and an ID token audience, either will do
+	b.token, b.audience = "secret", "https://example.com"
+	b.validate = func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+		if token != "id-token" || audience != "https://example.com" {
+			return nil, fmt.Errorf("invalid token")
+		}
+		return &idtoken.Payload{Audience: audience}, nil
+	}
+
+	for _, test := range []struct {
+		path, auth string
+		status     int
+	}{
+		{"/results.csv", "", http.StatusUnauthorized},
+		{"/results.csv", "wrong", http.StatusUnauthorized},
+		{"/results.csv", "secret", http.StatusOK},
+		{"/results.csv?token=secret", "", http.StatusOK},
+		{"/results.csv", "id-token", http.StatusOK},
+		{"/", "", http.StatusUnauthorized},
+	} {
+		if status, _, _ := get(test.path, test.auth); status != test.status {
+			t.Errorf("%s with %q: got %d, want %d", test.path, test.auth, status, test.status)
+		}
+	}
+
+	// A browser given the token in the query can follow the redirect to a directory, and then its links
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	browser := &http.Client{Jar: jar}
+	for _, path := range []string{"/run_date=2026-10-01?token=secret", "/run_date=2026-10-01/club.json"} {
+		resp, err := browser.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got %d from a browser", path, resp.StatusCode)
+		}
+	}
+}
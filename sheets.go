@@ -15,36 +15,36 @@ type spreadsheetWriter struct {
 	srv          *sheets.Service
 	min_rows     int
 	max_rows     int
-	max_cols     rune
+	max_cols     string
 	batch_length int // Write to spreadsheet every time we get to this number of rows
 	values       [][]string
 	id           string // Id is the identifier in the sheet's URL
 	sheet        string // Sheet is the name of the sheet we're writing to
 }
 
-func NewSpreadsheetWriter(ctx context.Context, spreadsheetID string, spreadsheetSheet string) (*spreadsheetWriter, error) {
+// NewSpreadsheetWriter clears the sheet ready for rows with this number of columns
+func NewSpreadsheetWriter(ctx context.Context, spreadsheetID string, spreadsheetSheet string, cols int) (*spreadsheetWriter, error) {
 	log.Printf("Getting new spreadsheetWriter")
 	srv, err := sheets.NewService(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("getting NewSpreadsheetWriter: %v", err)
 	}
 
-	// Start at row 2 to leave the header row in place
+	// The header row goes in row 1, followed by the data
 	sw := spreadsheetWriter{
-		min_rows:     2,
-		max_rows:     2,
-		max_cols:     'A',
+		min_rows:     1,
+		max_rows:     1,
+		max_cols:     columnName(cols),
 		batch_length: 10,
 		id:           spreadsheetID,
 		sheet:        spreadsheetSheet,
 		srv:          srv,
 	}
 
-	// Clear the current contents, from second row on. This should leave the formatting intact
+	// Clear the current contents of the whole sheet, however many rows and columns the last run wrote. This should
+	// leave the formatting intact
 	clearRequest := sheets.BatchClearValuesRequest{
-		Ranges: []string{
-			fmt.Sprintf("%s!A2:N150", sw.sheet),
-		},
+		Ranges: []string{sw.sheet},
 	}
 	_, err = srv.Spreadsheets.Values.BatchClear(sw.id, &clearRequest).Do()
 	if err != nil {
@@ -74,7 +74,7 @@ func NewSpreadsheetWriter(ctx context.Context, spreadsheetID string, spreadsheet
 			EndRowIndex:      1,
 			EndColumnIndex:   1,
 		},
-		Fields: "*",
+		Fields: "note",
 		Rows: []*sheets.RowData{{
 			Values: []*sheets.CellData{{
 				Note: fmt.Sprintf("Last updated: %s", time.Now().Format("2006-January-02")),
@@ -100,12 +100,9 @@ func (sw spreadsheetWriter) Write(p []byte) (n int, err error) {
 }
 
 func (sw *spreadsheetWriter) WriteRow(record []string) error {
-	log.Printf("Appending row to spreadsheet for rider %s, length %d", record[0], len(record))
+	log.Printf("Appending row to spreadsheet for %s, length %d", record[0], len(record))
 	sw.values = append(sw.values, record)
 	sw.max_rows += 1
-
-	// Ideally we'd work this out based on the data
-	sw.max_cols = 'N'
 	log.Printf("Spreadsheet data has %d rows", len(sw.values))
 
 	if len(sw.values) >= sw.batch_length {
@@ -117,8 +114,7 @@ func (sw *spreadsheetWriter) WriteRow(record []string) error {
 }
 
 func (sw *spreadsheetWriter) Flush() {
-	// Start at row 2 to leave the header row intact
-	rangeData := fmt.Sprintf("%s!A%d:%c%d", sw.sheet, sw.min_rows, sw.max_cols, sw.max_rows)
+	rangeData := fmt.Sprintf("%s!A%d:%s%d", sw.sheet, sw.min_rows, sw.max_cols, sw.max_rows)
 	log.Printf("Writing data to spreadsheet range %s, length %d", rangeData, len(sw.values))
 	values := make([][]interface{}, len(sw.values))
 	for i, row := range sw.values {
@@ -152,6 +148,17 @@ func (sw *spreadsheetWriter) Close() error {
 	return nil
 }
 
+// columnName turns a column number, counting from 1, into its spreadsheet letters: A, B, ... Z, AA, AB ...
+func columnName(col int) string {
+	name := ""
+	for col > 0 {
+		col--
+		name = string(rune('A'+col%26)) + name
+		col /= 26
+	}
+	return name
+}
+
 type rowWriter interface {
 	WriteRow(record []string) error
 	Flush()
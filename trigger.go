@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// triggerClubID is the club that /trigger imports if the request doesn't say
+const triggerClubID = 2672
+
+// triggerRequest is what /trigger accepts, either as query parameters or as a JSON body
+type triggerRequest struct {
+	Club        int    `json:"club"`
+	Limit       *int   `json:"limit"`
+	Format      string `json:"format"`
+	Spreadsheet string `json:"spreadsheet"`
+	Sheet       string `json:"sheet"`
+	// Object is the name to write the results as in the storage bucket
+	Object string `json:"object"`
+}
+
+// objectName is what we allow for the names of results in the bucket
+var objectName = regexp.MustCompile(`^[A-Za-z0-9_\-]+(/[A-Za-z0-9_\-]+)*\.(csv|json|ndjson)$`)
+
+// triggerOptions works out the import options for a /trigger request. Anything the request doesn't give comes
+// from the service's own settings. Clubs and spreadsheets have to be on the allow-lists in ALLOWED_CLUBS and
+// ALLOWED_SPREADSHEETS, as well as the defaults. If there's a problem, it gives the HTTP status to respond with.
+func triggerOptions(r *http.Request) (Options, int, error) {
+	var req triggerRequest
+	if r.Body != nil && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return Options{}, http.StatusBadRequest, fmt.Errorf("can't parse request: %v", err)
+		}
+	}
+
+	q := r.URL.Query()
+	if s := q.Get("club"); s != "" {
+		club, err := strconv.Atoi(s)
+		if err != nil {
+			return Options{}, http.StatusBadRequest, fmt.Errorf("bad club ID %q", s)
+		}
+		req.Club = club
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return Options{}, http.StatusBadRequest, fmt.Errorf("bad limit %q", s)
+		}
+		req.Limit = &limit
+	}
+	for name, v := range map[string]*string{
+		"format":      &req.Format,
+		"spreadsheet": &req.Spreadsheet,
+		"sheet":       &req.Sheet,
+		"object":      &req.Object,
+	} {
+		if s := q.Get(name); s != "" {
+			*v = s
+		}
+	}
+
+	opts := flagOptions(triggerClubID)
+	if req.Club != 0 {
+		opts.ClubID = req.Club
+	}
+	if req.Limit != nil {
+		opts.Limit = *req.Limit
+	}
+	if req.Format != "" {
+		opts.Format = req.Format
+	}
+
+	// A destination in the request replaces the service's own one altogether
+	if req.Spreadsheet != "" || req.Object != "" {
+		sheet := req.Sheet
+		if sheet == "" && req.Spreadsheet == SpreadsheetID {
+			sheet = SpreadsheetSheet
+		}
+		opts.SpreadsheetID, opts.SpreadsheetSheet, opts.Filename = req.Spreadsheet, sheet, req.Object
+	} else if req.Sheet != "" {
+		// A sheet on its own is in the service's own spreadsheet
+		if opts.SpreadsheetID == "" {
+			return opts, http.StatusBadRequest, fmt.Errorf("sheet %s needs a spreadsheet", req.Sheet)
+		}
+		opts.SpreadsheetSheet = req.Sheet
+	}
+
+	if !allowed(os.Getenv("ALLOWED_CLUBS"), strconv.Itoa(opts.ClubID), strconv.Itoa(triggerClubID)) {
+		return opts, http.StatusForbidden, fmt.Errorf("club %d isn't allowed", opts.ClubID)
+	}
+	if opts.SpreadsheetID != "" && !allowed(os.Getenv("ALLOWED_SPREADSHEETS"), opts.SpreadsheetID, SpreadsheetID) {
+		return opts, http.StatusForbidden, fmt.Errorf("spreadsheet %s isn't allowed", opts.SpreadsheetID)
+	}
+	if opts.SpreadsheetID != "" && opts.SpreadsheetSheet == "" {
+		return opts, http.StatusBadRequest, fmt.Errorf("need a sheet name to write to spreadsheet %s", opts.SpreadsheetID)
+	}
+	if req.Object != "" {
+		if storageClient == nil {
+			return opts, http.StatusBadRequest, fmt.Errorf("there's no storage bucket to write %s to", req.Object)
+		}
+		if !objectName.MatchString(req.Object) || reservedObject(req.Object) {
+			return opts, http.StatusBadRequest, fmt.Errorf("bad object name %q", req.Object)
+		}
+		if path.Ext(req.Object) != "."+outputExtension(opts.Format) {
+			return opts, http.StatusBadRequest, fmt.Errorf("object %s doesn't match format %s", req.Object, outputExtension(opts.Format))
+		}
+	}
+
+	if err := opts.check(); err != nil {
+		return opts, http.StatusBadRequest, err
+	}
+	return opts, http.StatusOK, nil
+}
+
+// reservedObject says whether the name is somewhere the service keeps its own data in the bucket, which requests
+// mustn't overwrite
+func reservedObject(name string) bool {
+	for _, prefix := range []string{"members", CacheDir, ParquetDir} {
+		prefix = strings.Trim(prefix, "/")
+		if prefix != "" && strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed says whether value is in the comma-separated allow-list, or is the default
+func allowed(list, value, def string) bool {
+	if value == def {
+		return true
+	}
+	for _, a := range strings.Split(list, ",") {
+		if strings.TrimSpace(a) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+	"google.golang.org/api/option"
+)
+
+func TestTriggerOptions(t *testing.T) {
+	SpreadsheetID, SpreadsheetSheet = "default-sheet", "Default"
+	os.Setenv("ALLOWED_CLUBS", "2740, 1234")
+	os.Setenv("ALLOWED_SPREADSHEETS", "other-sheet")
+	defer func() {
+		SpreadsheetID, SpreadsheetSheet = "", ""
+		os.Unsetenv("ALLOWED_CLUBS")
+		os.Unsetenv("ALLOWED_SPREADSHEETS")
+	}()
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		status   int
+		expected Options
+	}{
+		{"defaults", "/trigger", "", http.StatusOK, Options{ClubID: 2672, SpreadsheetID: "default-sheet", SpreadsheetSheet: "Default"}},
+		{"query", "/trigger?club=2740&limit=5&spreadsheet=other-sheet&sheet=Riders", "", http.StatusOK,
+			Options{ClubID: 2740, Limit: 5, SpreadsheetID: "other-sheet", SpreadsheetSheet: "Riders"}},
+		{"JSON body", "/trigger", `{"club":1234,"limit":0,"spreadsheet":"other-sheet","sheet":"Riders"}`, http.StatusOK,
+			Options{ClubID: 1234, SpreadsheetID: "other-sheet", SpreadsheetSheet: "Riders"}},
+		{"default spreadsheet keeps its sheet", "/trigger?spreadsheet=default-sheet", "", http.StatusOK,
+			Options{ClubID: 2672, SpreadsheetID: "default-sheet", SpreadsheetSheet: "Default"}},
+		{"no sheet", "/trigger?spreadsheet=other-sheet", "", http.StatusBadRequest, Options{}},
+		{"sheet in the default spreadsheet", "/trigger?club=2740&sheet=Club2740", "", http.StatusOK,
+			Options{ClubID: 2740, SpreadsheetID: "default-sheet", SpreadsheetSheet: "Club2740"}},
+		{"query beats body", "/trigger?club=2740", `{"club":1234}`, http.StatusOK,
+			Options{ClubID: 2740, SpreadsheetID: "default-sheet", SpreadsheetSheet: "Default"}},
+		{"club not allowed", "/trigger?club=999", "", http.StatusForbidden, Options{}},
+		{"spreadsheet not allowed", "/trigger?spreadsheet=someone-elses", "", http.StatusForbidden, Options{}},
+		{"bad club", "/trigger?club=revo", "", http.StatusBadRequest, Options{}},
+		{"JSON to a spreadsheet", "/trigger?format=json", "", http.StatusBadRequest, Options{}},
+		{"no bucket", "/trigger?object=team.csv", "", http.StatusBadRequest, Options{}},
+		{"bad JSON", "/trigger", `{"club":`, http.StatusBadRequest, Options{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := http.MethodGet
+			if test.body != "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, test.url, strings.NewReader(test.body))
+			if test.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			opts, status, err := triggerOptions(r)
+			if status != test.status {
+				t.Fatalf("Got status %d (%v), want %d", status, err, test.status)
+			}
+			if status == http.StatusOK && opts != test.expected {
+				t.Errorf("Got %+v, want %+v", opts, test.expected)
+			}
+		})
+	}
+}
+
+func TestTriggerObjects(t *testing.T) {
+	var err error
+	storageClient, err = storage.NewClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	CacheDir = "zpcache"
+	defer func() {
+		storageClient.Close()
+		storageClient = nil
+		CacheDir = ""
+	}()
+
+	for object, status := range map[string]int{
+		"teams/cryo-gen.csv":         http.StatusOK,
+		"teams/cryo-gen.json":        http.StatusBadRequest,
+		"members/club_2672.csv":      http.StatusBadRequest,
+		"zpcache/profile_cache.csv":  http.StatusBadRequest,
+		"../results.csv":             http.StatusBadRequest,
+		"teams/cryo-gen.csv?x=1.csv": http.StatusBadRequest,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/trigger?object="+url.QueryEscape(object), nil)
+		if _, got, err := triggerOptions(r); got != status {
+			t.Errorf("%s: got status %d (%v), want %d", object, got, err, status)
+		}
+	}
+
+	// Without a spreadsheet of the service's own, a sheet has nowhere to go
+	r := httptest.NewRequest(http.MethodGet, "/trigger?sheet=Club2740", nil)
+	if _, got, _ := triggerOptions(r); got != http.StatusBadRequest {
+		t.Errorf("Got status %d for a sheet without a spreadsheet", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/trigger?format=json&object=members/club_2672.json", nil)
+	if _, got, _ := triggerOptions(r); got != http.StatusBadRequest {
+		t.Errorf("Got status %d for the members file", got)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	srv := zptest.NewServer("zp/testdata")
+	defer srv.Close()
+
+	BaseURL = srv.URL
+	Filename = t.TempDir() + "/results.csv"
+	defer func() {
+		BaseURL = ""
+		Filename = ""
+	}()
+
+	rec := httptest.NewRecorder()
+	HelloZP(rec, httptest.NewRequest(http.MethodPost, "/trigger?limit=1", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Imported 1 riders for club 2672") {
+		t.Errorf("Got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	HelloZP(rec, httptest.NewRequest(http.MethodPost, "/trigger?club=999", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Got %d for a club that isn't allowed", rec.Code)
+	}
+}
@@ -0,0 +1,121 @@
+package zp
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheStore keeps ZwiftPower responses, such as on disk or in a storage bucket.
+// Keys are slash-separated paths, like www.zwiftpower.com/cache3/teams/2672_riders.json.
+type CacheStore interface {
+	// Get gives the data stored under key, and when it was stored. ok is false if there's nothing there.
+	Get(key string) (data []byte, stored time.Time, ok bool, err error)
+	// Put stores data under key
+	Put(key string, data []byte) error
+}
+
+// Cache keeps the club list and riders' data for a while, so repeated runs don't have to fetch them again
+type Cache struct {
+	Store CacheStore
+	// TeamTTL is how long a club's rider list is kept. Zero means it isn't cached
+	TeamTTL time.Duration
+	// ProfileTTL is how long a rider's data is kept. Zero means it isn't cached
+	ProfileTTL time.Duration
+}
+
+// ttl is how long the response from this URL should be kept
+func (c *Cache) ttl(u *url.URL) time.Duration {
+	switch {
+	case strings.HasPrefix(u.Path, "/cache3/teams/"):
+		return c.TeamTTL
+	case strings.HasPrefix(u.Path, "/cache3/profile/"):
+		return c.ProfileTTL
+	}
+	return 0
+}
+
+// cacheKey turns a URL into a cache key, or "" if its responses shouldn't be cached
+func (c *Client) cacheKey(rawurl string) string {
+	if c.Cache == nil || c.Cache.Store == nil {
+		return ""
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil || c.Cache.ttl(u) <= 0 || u.RawQuery != "" {
+		return ""
+	}
+	return path.Join(u.Host, u.Path)
+}
+
+// fromCache gives the cached response for the URL, if there's one that hasn't expired
+func (c *Client) fromCache(rawurl string) ([]byte, bool) {
+	key := c.cacheKey(rawurl)
+	if key == "" {
+		return nil, false
+	}
+
+	data, stored, ok, err := c.Cache.Store.Get(key)
+	if err != nil {
+		log.Printf("Reading %s from cache: %v", key, err)
+		return nil, false
+	}
+	u, _ := url.Parse(rawurl)
+	if !ok || time.Since(stored) > c.Cache.ttl(u) {
+		return nil, false
+	}
+
+	log.Printf("Using cached %s", key)
+	return data, true
+}
+
+// toCache keeps the response for the URL, if it's one we cache
+func (c *Client) toCache(rawurl string, data []byte) {
+	key := c.cacheKey(rawurl)
+	if key == "" {
+		return
+	}
+
+	if err := c.Cache.Store.Put(key, data); err != nil {
+		log.Printf("Writing %s to cache: %v", key, err)
+	}
+}
+
+// DiskCache is a CacheStore that keeps responses as files under a directory
+type DiskCache struct {
+	Dir string
+}
+
+// Get reads the file for key, using its modification time as the time it was stored
+func (d DiskCache) Get(key string) ([]byte, time.Time, bool, error) {
+	filename := filepath.Join(d.Dir, filepath.FromSlash(key))
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return data, info.ModTime(), true, nil
+}
+
+// Put writes the file for key, creating directories as needed
+func (d DiskCache) Put(key string, data []byte) error {
+	filename := filepath.Join(d.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a concurrent reader never sees half a response
+	return writeFileAtomic(filename, data)
+}
@@ -0,0 +1,123 @@
+package zp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/cache3/teams/2672_riders.json":
+			fmt.Fprint(w, `{"data":[{"zwid":1,"name":"One"}]}`)
+		case "/cache3/profile/1_all.json":
+			fmt.Fprint(w, `{"data":[{"event_title":"Race 1","f_t":"TYPE_RACE","event_date":1601736300,"wkg_ftp":["2.5",0]}]}`)
+		case "/cache3/profile/2_all.json":
+			http.Error(w, "gone", http.StatusNotFound)
+		case "/cache3/profile/3_all.json":
+			fmt.Fprint(w, `{"data":`)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.Cache = &Cache{
+		Store:      DiskCache{Dir: t.TempDir()},
+		TeamTTL:    time.Hour,
+		ProfileTTL: time.Hour,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ImportZP(client, 2672); err != nil {
+			t.Fatalf("ImportZP: %v", err)
+		}
+		rider, err := ImportRider(client, 1)
+		if err != nil {
+			t.Fatalf("ImportRider: %v", err)
+		}
+		if rider.LatestRace != "Race 1" {
+			t.Errorf("Got latest race %q from cache", rider.LatestRace)
+		}
+
+		// Errors aren't cached
+		if _, err := ImportRider(client, 2); err == nil {
+			t.Errorf("Expected error for missing rider")
+		}
+		// Nor is data that can't be parsed
+		if _, err := ImportRider(client, 3); err == nil {
+			t.Errorf("Expected error for bad data")
+		}
+	}
+
+	expected := map[string]int{
+		"/cache3/teams/2672_riders.json": 1,
+		"/cache3/profile/1_all.json":     1,
+		"/profile.php":                   5,
+		"/cache3/profile/2_all.json":     2,
+		"/cache3/profile/3_all.json":     2,
+	}
+	for path, n := range expected {
+		if hits[path] != n {
+			t.Errorf("%s: got %d requests, want %d", path, hits[path], n)
+		}
+	}
+
+	// Once it's expired, it's fetched again
+	client.Cache.ProfileTTL = time.Nanosecond
+	if _, err := ImportRider(client, 1); err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+	if hits["/cache3/profile/1_all.json"] != 2 {
+		t.Errorf("Expired profile wasn't fetched again")
+	}
+
+	// And a zero TTL means not cached at all
+	client.Cache.TeamTTL = 0
+	if _, err := ImportZP(client, 2672); err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if hits["/cache3/teams/2672_riders.json"] != 2 {
+		t.Errorf("Uncached team list wasn't fetched again")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	d := DiskCache{Dir: t.TempDir()}
+	if _, _, ok, err := d.Get("example.com/cache3/teams/1_riders.json"); ok || err != nil {
+		t.Fatalf("Get from empty cache: ok %t, err %v", ok, err)
+	}
+
+	if err := d.Put("example.com/cache3/teams/1_riders.json", []byte("{}")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	data, stored, ok, err := d.Get("example.com/cache3/teams/1_riders.json")
+	if !ok || err != nil || string(data) != "{}" || time.Since(stored) > time.Minute {
+		t.Errorf("Get: got %q, %v, %t, %v", data, stored, ok, err)
+	}
+
+	// Writers of the same key mustn't get in each other's way
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.Put("example.com/cache3/teams/1_riders.json", []byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+				t.Errorf("Put: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
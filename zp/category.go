@@ -0,0 +1,164 @@
+package zp
+
+import "time"
+
+// Category is a ZwiftPower pace category
+type Category string
+
+// Categories, fastest first. E is open to anyone, so it's never estimated from power.
+const (
+	CategoryAPlus Category = "A+"
+	CategoryA     Category = "A"
+	CategoryB     Category = "B"
+	CategoryC     Category = "C"
+	CategoryD     Category = "D"
+	CategoryE     Category = "E"
+)
+
+// CategoryLimit is the least FTP, in W/kg and in watts, that puts a rider in a category
+type CategoryLimit struct {
+	Category Category
+	Wkg      float64
+	// Watts is the least FTP in watts as well. Zero means there's no minimum
+	Watts float64
+}
+
+// CategoryRules are the limits for each category, fastest first, and how they're applied
+type CategoryRules struct {
+	// Days is how far back race results count
+	Days int
+	// Open are the limits for everyone; Women are the limits for the women's categories
+	Open  []CategoryLimit
+	Women []CategoryLimit
+	// UpgradeMargin is how close, in W/kg, a rider's best result can get to the next category up before
+	// they're flagged as at risk of an upgrade
+	UpgradeMargin float64
+	// CP20Factor and CP5Factor turn a race's best 20 and 5 minute power into FTP estimates, which count when
+	// they're higher than ZwiftPower's own FTP figure. Zero leaves that power out.
+	CP20Factor float64
+	CP5Factor  float64
+}
+
+// DefaultCategoryRules are ZwiftPower's pace category rules: the best FTP from races in the last 60 days, with
+// minimum watts for the open categories. FTP is also estimated as 95% of 20 minute power, as ZwiftPower does, and
+// 85% of 5 minute power.
+var DefaultCategoryRules = CategoryRules{
+	Days: 60,
+	Open: []CategoryLimit{
+		{CategoryAPlus, 4.6, 250},
+		{CategoryA, 4.0, 250},
+		{CategoryB, 3.2, 200},
+		{CategoryC, 2.5, 150},
+		{CategoryD, 0, 0},
+	},
+	Women: []CategoryLimit{
+		{CategoryA, 3.7, 0},
+		{CategoryB, 3.2, 0},
+		{CategoryC, 2.5, 0},
+		{CategoryD, 0, 0},
+	},
+	UpgradeMargin: 0.1,
+	CP20Factor:    0.95,
+	CP5Factor:     0.85,
+}
+
+// CategoryEstimate is the category a rider's recent race results put them in
+type CategoryEstimate struct {
+	// Category is the open category, or "" if there are no recent results to go on
+	Category Category
+	// WomenCategory is the women's category, for riders whose results are in the women's races
+	WomenCategory Category
+	// Wkg and Watts are the best FTP from recent races, that the categories are based on
+	Wkg   float64
+	Watts float64
+	// UpgradeRisk is set if a recent result was flagged by ZwiftPower as an upgrade, or if the best result is
+	// within the rules' UpgradeMargin of the next category up
+	UpgradeRisk bool
+}
+
+// EstimateCategory works out the rider's category from their race results in the rules' window before asOf
+func EstimateCategory(events []Event, asOf time.Time, rules CategoryRules) CategoryEstimate {
+	var est CategoryEstimate
+	open, women := -1, -1
+	female := false
+	since := asOf.AddDate(0, 0, -rules.Days)
+
+	for _, e := range events {
+		if e.Male.Valid {
+			female = e.Male.Value == 0
+		}
+		if !e.IsRace() || e.EventDate.Before(since) || e.EventDate.After(asOf) {
+			continue
+		}
+		wkg, watts, ok := eventFtp(e, rules)
+		if !ok {
+			continue
+		}
+
+		if i := categoryIndex(rules.Open, wkg, watts); open < 0 || i < open {
+			open = i
+		}
+		if i := categoryIndex(rules.Women, wkg, 0); women < 0 || i < women {
+			women = i
+		}
+		if wkg > est.Wkg {
+			est.Wkg, est.Watts = wkg, watts
+		}
+		if e.Upgrade.Value != 0 {
+			est.UpgradeRisk = true
+		}
+	}
+
+	if open < 0 {
+		return CategoryEstimate{}
+	}
+
+	est.Category = rules.Open[open].Category
+	limits, i := rules.Open, open
+	if female {
+		est.WomenCategory = rules.Women[women].Category
+		limits, i = rules.Women, women
+	}
+	if i > 0 && est.Wkg >= limits[i-1].Wkg-rules.UpgradeMargin {
+		est.UpgradeRisk = true
+	}
+	return est
+}
+
+// eventFtp gives the FTP a race shows, in W/kg and watts: the best of ZwiftPower's FTP figure and the estimates
+// from 20 and 5 minute power. Watts are worked out from the rider's weight if they aren't given, or are zero if
+// that isn't known either. ok is false if there's nothing to go on.
+func eventFtp(e Event, rules CategoryRules) (wkg, watts float64, ok bool) {
+	for _, p := range []struct {
+		wkg, watts Tuple
+		factor     float64
+	}{
+		{e.WkgFtp, e.WattsFtp, 1},
+		{e.Wkg1200, e.Watts1200, rules.CP20Factor},
+		{e.Wkg300, e.Watts300, rules.CP5Factor},
+	} {
+		if !p.wkg.Valid || p.factor == 0 || (ok && p.wkg.Value*p.factor <= wkg) {
+			continue
+		}
+
+		wkg, watts, ok = p.wkg.Value*p.factor, 0, true
+		switch {
+		case p.watts.Valid:
+			watts = p.watts.Value * p.factor
+		case e.Weight.Valid:
+			watts = wkg * e.Weight.Value
+		}
+	}
+	return wkg, watts, ok
+}
+
+// categoryIndex finds the fastest category whose limits this FTP meets. Watts of zero means they aren't known,
+// so only W/kg counts.
+func categoryIndex(limits []CategoryLimit, wkg, watts float64) int {
+	for i, l := range limits {
+		if wkg >= l.Wkg && (watts == 0 || watts >= l.Watts) {
+			return i
+		}
+	}
+	return len(limits) - 1
+}
@@ -0,0 +1,87 @@
+package zp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+)
+
+func TestEstimateCategory(t *testing.T) {
+	asOf := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	race := func(daysAgo int, wkg, watts float64) Event {
+		return Event{
+			EventType: "TYPE_RACE",
+			EventDate: asOf.AddDate(0, 0, -daysAgo),
+			WkgFtp:    Tuple{Value: wkg, Valid: true},
+			WattsFtp:  Tuple{Value: watts, Valid: watts != 0},
+			Male:      Tuple{Value: 1, Valid: true},
+		}
+	}
+	woman := func(e Event) Event {
+		e.Male = Tuple{Value: 0, Valid: true}
+		return e
+	}
+	cp := func(e Event, wkg20, watts20, wkg5, watts5 float64) Event {
+		e.Wkg1200, e.Watts1200 = Tuple{Value: wkg20, Valid: wkg20 != 0}, Tuple{Value: watts20, Valid: watts20 != 0}
+		e.Wkg300, e.Watts300 = Tuple{Value: wkg5, Valid: wkg5 != 0}, Tuple{Value: watts5, Valid: watts5 != 0}
+		return e
+	}
+	upgraded := func(e Event) Event {
+		e.Upgrade = Tuple{Value: 1, Valid: true}
+		return e
+	}
+
+	tests := []struct {
+		name     string
+		events   []Event
+		expected CategoryEstimate
+	}{
+		{"no races", []Event{{EventType: "TYPE_RIDE", EventDate: asOf, WkgFtp: Tuple{Value: 5, Valid: true}}}, CategoryEstimate{}},
+		{"too long ago", []Event{race(61, 4.2, 300)}, CategoryEstimate{}},
+		{"best result counts", []Event{race(50, 3.3, 240), race(10, 2.8, 200)}, CategoryEstimate{Category: CategoryB, Wkg: 3.3, Watts: 240}},
+		{"not enough watts", []Event{race(10, 4.1, 230)}, CategoryEstimate{Category: CategoryB, Wkg: 4.1, Watts: 230, UpgradeRisk: true}},
+		{"watts from weight", []Event{{EventType: "TYPE_RACE", EventDate: asOf, WkgFtp: Tuple{Value: 4.7, Valid: true}, Weight: Tuple{Value: 70, Valid: true}}},
+			CategoryEstimate{Category: CategoryAPlus, Wkg: 4.7, Watts: 329}},
+		{"close to next category", []Event{race(5, 3.15, 250)}, CategoryEstimate{Category: CategoryC, Wkg: 3.15, Watts: 250, UpgradeRisk: true}},
+		{"flagged upgrade", []Event{upgraded(race(5, 2.6, 180))}, CategoryEstimate{Category: CategoryC, Wkg: 2.6, Watts: 180, UpgradeRisk: true}},
+		{"women's category", []Event{woman(race(5, 3.8, 220))}, CategoryEstimate{Category: CategoryB, WomenCategory: CategoryA, Wkg: 3.8, Watts: 220}},
+		{"D", []Event{race(5, 2.0, 140)}, CategoryEstimate{Category: CategoryD, Wkg: 2.0, Watts: 140}},
+		{"20 minute power", []Event{cp(race(5, 3.0, 210), 3.6, 260, 0, 0)}, CategoryEstimate{Category: CategoryB, Wkg: 3.42, Watts: 247}},
+		{"5 minute power", []Event{cp(race(5, 3.0, 210), 0, 0, 4.0, 280)}, CategoryEstimate{Category: CategoryB, Wkg: 3.4, Watts: 238}},
+		{"critical power lower than FTP", []Event{cp(race(5, 3.0, 210), 3.1, 217, 3.5, 245)}, CategoryEstimate{Category: CategoryC, Wkg: 3.0, Watts: 210}},
+		{"critical power only", []Event{cp(Event{EventType: "TYPE_RACE", EventDate: asOf}, 2.0, 0, 0, 0)}, CategoryEstimate{Category: CategoryD, Wkg: 1.9}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := EstimateCategory(test.events, asOf, DefaultCategoryRules)
+			if got != test.expected {
+				t.Errorf("Got %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCategoryFromFixtures(t *testing.T) {
+	srv := zptest.NewServer("testdata")
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.AsOf = time.Date(2021, 2, 5, 0, 0, 0, 0, time.UTC)
+
+	rider, err := ImportRider(client, 1261784)
+	if err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+
+	// 95% of their best 20 minute power is a little more than ZwiftPower's FTP figure
+	expected := CategoryEstimate{Category: CategoryC, WomenCategory: CategoryC, Wkg: 2.945, Watts: 163.4, UpgradeRisk: true}
+	if rider.Category != expected {
+		t.Errorf("Got %+v, want %+v", rider.Category, expected)
+	}
+}
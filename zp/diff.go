@@ -0,0 +1,140 @@
+package zp
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Membership compares two lists of club members, giving the riders who are only in the new list and those who
+// are only in the old one. Riders are matched by Zwid, and come back in the order of the list they're in.
+func Membership(old, new []Rider) (joined, left []Rider) {
+	inOld := map[int]bool{}
+	for _, r := range old {
+		inOld[r.Zwid] = true
+	}
+	inNew := map[int]bool{}
+	for _, r := range new {
+		inNew[r.Zwid] = true
+		if !inOld[r.Zwid] {
+			joined = append(joined, r)
+		}
+	}
+	for _, r := range old {
+		if !inNew[r.Zwid] {
+			left = append(left, r)
+		}
+	}
+	return joined, left
+}
+
+// DiffOptions say what counts as a change worth reporting
+type DiffOptions struct {
+	// FtpThreshold is how much Ftp30 or Ftp90 has to change by, in W/kg, to be reported
+	FtpThreshold float64
+	// InactiveDays is how long without an event before a rider counts as inactive
+	InactiveDays int
+}
+
+// DefaultDiffOptions are what captains asked for: 0.2 W/kg, and 30 days without an event
+var DefaultDiffOptions = DiffOptions{FtpThreshold: 0.2, InactiveDays: 30}
+
+// FtpChange is a rider whose FTP over 30 or 90 days changed by more than the threshold
+type FtpChange struct {
+	Rider Rider
+	// Stat is ftp30 or ftp90
+	Stat string
+	Old  float64
+	New  float64
+}
+
+// DiffReport is what changed between two snapshots of a club
+type DiffReport struct {
+	Joined     []Rider
+	Left       []Rider
+	FtpChanges []FtpChange
+	// BecameInactive are riders who'd had an event within InactiveDays of the old snapshot, but haven't
+	// within InactiveDays of the new one
+	BecameInactive []Rider
+}
+
+// Diff compares two snapshots of a club. Each rider's AsOf is taken as the time of their snapshot; if it's zero,
+// now is used instead.
+func Diff(old, new []Rider, opts DiffOptions) DiffReport {
+	var report DiffReport
+	report.Joined, report.Left = Membership(old, new)
+
+	before := map[int]Rider{}
+	for _, r := range old {
+		before[r.Zwid] = r
+	}
+
+	for _, r := range new {
+		o, ok := before[r.Zwid]
+		if !ok {
+			continue
+		}
+
+		if changed(o.Ftp30, r.Ftp30, opts.FtpThreshold) {
+			report.FtpChanges = append(report.FtpChanges, FtpChange{Rider: r, Stat: "ftp30", Old: o.Ftp30, New: r.Ftp30})
+		}
+		if changed(o.Ftp90, r.Ftp90, opts.FtpThreshold) {
+			report.FtpChanges = append(report.FtpChanges, FtpChange{Rider: r, Stat: "ftp90", Old: o.Ftp90, New: r.Ftp90})
+		}
+
+		if opts.InactiveDays > 0 && active(o, opts.InactiveDays) && !active(r, opts.InactiveDays) {
+			report.BecameInactive = append(report.BecameInactive, r)
+		}
+	}
+
+	// Biggest changes first
+	sort.SliceStable(report.FtpChanges, func(i, j int) bool {
+		return abs(report.FtpChanges[i].New-report.FtpChanges[i].Old) > abs(report.FtpChanges[j].New-report.FtpChanges[j].Old)
+	})
+	return report
+}
+
+func changed(old, new, threshold float64) bool {
+	return abs(new-old) > threshold+1e-9
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// active says whether the rider had an event within days of the time of their stats
+func active(r Rider, days int) bool {
+	asOf := r.AsOf
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	return !r.LatestEventDate.IsZero() && !r.LatestEventDate.Before(asOf.AddDate(0, 0, -days))
+}
+
+// DiffHeader names the fields from DiffReport.Rows
+var DiffHeader = []string{"Change", "Name", "Zwid", "Old", "New"}
+
+// Rows turns the report into a table, one row per change, in the same order as DiffHeader
+func (d DiffReport) Rows() [][]string {
+	var rows [][]string
+	row := func(change string, r Rider, old, new string) {
+		rows = append(rows, []string{change, r.Name, strconv.Itoa(r.Zwid), old, new})
+	}
+
+	for _, r := range d.Joined {
+		row("joined", r, "", "")
+	}
+	for _, r := range d.Left {
+		row("left", r, "", "")
+	}
+	for _, c := range d.FtpChanges {
+		row(c.Stat, c.Rider, strconv.FormatFloat(c.Old, 'f', 1, 64), strconv.FormatFloat(c.New, 'f', 1, 64))
+	}
+	for _, r := range d.BecameInactive {
+		row("inactive", r, "", formatDate(r.LatestEventDate, English))
+	}
+	return rows
+}
@@ -0,0 +1,85 @@
+package zp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	oldAsOf := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	newAsOf := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	old := []Rider{
+		{Name: "Stays", Zwid: 1, Ftp30: 3.0, Ftp90: 3.0, LatestEventDate: oldAsOf.AddDate(0, 0, -1), AsOf: oldAsOf},
+		{Name: "Leaves", Zwid: 2, AsOf: oldAsOf},
+		{Name: "Gets faster", Zwid: 3, Ftp30: 3.0, Ftp90: 3.0, LatestEventDate: oldAsOf.AddDate(0, 0, -1), AsOf: oldAsOf},
+		{Name: "Stops riding", Zwid: 4, Ftp30: 3.0, Ftp90: 3.0, LatestEventDate: oldAsOf.AddDate(0, 0, -5), AsOf: oldAsOf},
+		{Name: "Already stopped", Zwid: 5, LatestEventDate: oldAsOf.AddDate(0, -3, 0), AsOf: oldAsOf},
+	}
+	new := []Rider{
+		{Name: "Joins", Zwid: 6, AsOf: newAsOf},
+		{Name: "Stays", Zwid: 1, Ftp30: 3.2, Ftp90: 3.1, LatestEventDate: newAsOf.AddDate(0, 0, -1), AsOf: newAsOf},
+		{Name: "Gets faster", Zwid: 3, Ftp30: 3.5, Ftp90: 3.3, LatestEventDate: newAsOf.AddDate(0, 0, -1), AsOf: newAsOf},
+		{Name: "Stops riding", Zwid: 4, Ftp30: 0, Ftp90: 3.0, LatestEventDate: oldAsOf.AddDate(0, 0, -5), AsOf: newAsOf},
+		{Name: "Already stopped", Zwid: 5, LatestEventDate: oldAsOf.AddDate(0, -3, 0), AsOf: newAsOf},
+	}
+
+	report := Diff(old, new, DefaultDiffOptions)
+
+	expected := [][]string{
+		{"joined", "Joins", "6", "", ""},
+		{"left", "Leaves", "2", "", ""},
+		{"ftp30", "Stops riding", "4", "3.0", "0.0"},
+		{"ftp30", "Gets faster", "3", "3.0", "3.5"},
+		{"ftp90", "Gets faster", "3", "3.0", "3.3"},
+		{"inactive", "Stops riding", "4", "", "2020-12-27"},
+	}
+	if rows := report.Rows(); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Got\n%v\nwant\n%v", rows, expected)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	joined, left := Membership([]Rider{{Zwid: 1}, {Zwid: 2}}, []Rider{{Zwid: 2}, {Zwid: 3}, {Zwid: 4}})
+	if len(joined) != 2 || joined[0].Zwid != 3 || joined[1].Zwid != 4 {
+		t.Errorf("Joined: got %v", joined)
+	}
+	if len(left) != 1 || left[0].Zwid != 1 {
+		t.Errorf("Left: got %v", left)
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	rider := Rider{
+		Name:            "Liz Rice",
+		Zwid:            98588,
+		LatestEventDate: time.Date(2020, 4, 15, 0, 0, 0, 0, time.UTC),
+		LatestEvent:     "Group ride",
+		Rides:           2,
+		Races:           1,
+		Ftp30:           2.5,
+		Ftp90:           2.7,
+		LatestRace:      "Race",
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	schema := DefaultSchema(nil, Dutch)
+	w.Write(schema.Header())
+	w.Write(schema.Row(rider))
+	w.Flush()
+
+	riders, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if len(riders) != 1 || !reflect.DeepEqual(riders[0], rider) {
+		t.Errorf("Got %+v, want %+v", riders, rider)
+	}
+
+	if _, err := ReadSnapshot(bytes.NewBufferString("name,ftp30\nLiz,2.5\n")); err == nil {
+		t.Errorf("Expected error without a Zwid column")
+	}
+}
@@ -0,0 +1,98 @@
+package zp
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Importer imports data for many riders at once, using Workers concurrent requests.
+// Use the Client's RequestsPerSecond to stay friendly with ZwiftPower.
+type Importer struct {
+	Client *Client
+	// Workers is how many riders are imported at the same time. Zero means one at a time
+	Workers int
+	// State, if set, is used to skip riders whose club list entry hasn't changed since the last import,
+	// and to make conditional requests for the rest. It's updated as riders are imported; call its Save
+	// method afterwards.
+	State *State
+	// RefreshAfter is how old saved data for an unchanged rider can get before we check with ZwiftPower
+	// anyway. Zero means never.
+	RefreshAfter time.Duration
+}
+
+// RiderFunc is called with the result of importing the rider at index i in the club list
+type RiderFunc func(i int, profile RiderProfile, err error) error
+
+type result struct {
+	profile RiderProfile
+	err     error
+}
+
+// ImportRiders imports data and events for each of the riders, and calls fn with each result in the riders' original order.
+// Riders keep the names from the club list. If fn returns an error, the import stops and that error is returned.
+func (im *Importer) ImportRiders(riders []Rider, fn RiderFunc) error {
+	workers := im.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// One buffered channel per rider, so workers never block and results can be read back in order
+	results := make([]chan result, len(riders))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	jobs := make(chan int)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				profile, err := im.importRider(riders[i])
+				profile.Rider.Name = riders[i].Name
+				results[i] <- result{profile: profile, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range riders {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	defer wg.Wait()
+	defer close(done)
+
+	for i := range riders {
+		r := <-results[i]
+		if err := fn(i, r.profile, r.err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Failure records a rider whose data couldn't be imported
+type Failure struct {
+	Name string
+	Zwid int
+	Err  error
+}
+
+// Strings turns a failure into []string, in the same order as FailureHeader
+func (f Failure) Strings() []string {
+	return []string{f.Name, strconv.Itoa(f.Zwid), f.Err.Error()}
+}
+
+// FailureHeader names the fields from Failure.Strings
+var FailureHeader = []string{"Name", "Zwid", "Error"}
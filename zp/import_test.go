@@ -0,0 +1,102 @@
+package zp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImportRidersInOrder(t *testing.T) {
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+
+		var id int
+		fmt.Sscanf(r.URL.Path, "/cache3/profile/%d_all.json", &id)
+		// Earlier riders take longer, so they finish out of order
+		time.Sleep(time.Duration(10-id) * 2 * time.Millisecond)
+		fmt.Fprintf(w, `{"data":[{"event_title":"Race %d","f_t":"TYPE_RACE","event_date":1601736300,"avg_wkg":["2.7",0],"wkg_ftp":["2.5",0]}]}`, id)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	var riders []Rider
+	for i := 0; i < 8; i++ {
+		riders = append(riders, Rider{Zwid: i, Name: fmt.Sprintf("Rider %d", i)})
+	}
+
+	im := Importer{Client: client, Workers: 4}
+	var got []string
+	err = im.ImportRiders(riders, func(i int, profile RiderProfile, err error) error {
+		if err != nil {
+			t.Errorf("Rider %d: %v", i, err)
+		}
+		got = append(got, fmt.Sprintf("%s: %s", profile.Rider.Name, profile.Rider.LatestRace))
+		if len(profile.Events) != 1 {
+			t.Errorf("Rider %d: got %d events", i, len(profile.Events))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ImportRiders: %v", err)
+	}
+
+	for i, g := range got {
+		expected := fmt.Sprintf("Rider %d: Race %d", i, i)
+		if g != expected {
+			t.Errorf("Result %d: got %s expected %s", i, g, expected)
+		}
+	}
+	if len(got) != len(riders) {
+		t.Errorf("Got %d results, expected %d", len(got), len(riders))
+	}
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("Got up to %d concurrent requests, expected between 2 and 4", maxInFlight)
+	}
+}
+
+func TestImportRidersStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	riders := make([]Rider, 20)
+	im := Importer{Client: client, Workers: 3}
+	calls := 0
+	err = im.ImportRiders(riders, func(i int, profile RiderProfile, err error) error {
+		calls++
+		if i == 2 {
+			return fmt.Errorf("stop here")
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "stop here") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Got %d calls, expected 3", calls)
+	}
+}
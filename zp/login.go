@@ -0,0 +1,201 @@
+package zp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotLoggedIn is returned when ZwiftPower sends its login page instead of the data we asked for
+var ErrNotLoggedIn = errors.New("not logged in to ZwiftPower")
+
+// loginPath starts ZwiftPower's login flow, which redirects to the Zwift sign-in form
+const loginPath = "/ucp.php?mode=login&login=external&oauth_service=oauthzpsso"
+
+var formAction = regexp.MustCompile(`(?is)<form[^>]*\saction="([^"]*)"`)
+
+// Login signs in to ZwiftPower, leaving the session cookies in the client's cookie jar
+func (c *Client) Login(username, password string) error {
+	log.Printf("Logging in to ZwiftPower as %s", username)
+	resp, err := c.get(c.url(loginPath))
+	if err != nil {
+		return fmt.Errorf("getting login page: %v", err)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading login page: %v", err)
+	}
+
+	// The sign-in form could be on a different site, so resolve its action against wherever we ended up
+	m := formAction.FindSubmatch(page)
+	if m == nil {
+		return fmt.Errorf("no login form at %s", resp.Request.URL)
+	}
+	action, err := resp.Request.URL.Parse(html.UnescapeString(string(m[1])))
+	if err != nil {
+		return fmt.Errorf("parsing login form action: %v", err)
+	}
+
+	form := url.Values{
+		"username":   {username},
+		"password":   {password},
+		"rememberMe": {"on"},
+	}
+	req, err := http.NewRequest(http.MethodPost, action.String(), bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	c.limiter.wait(c.RequestsPerSecond)
+	hc := &http.Client{
+		Jar:       c.jar,
+		Transport: c.Transport,
+	}
+	resp, err = hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("submitting login form: %v", err)
+	}
+	page, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading login response: %v", err)
+	}
+
+	// A successful login redirects back to ZwiftPower; a failed one shows the form again
+	if resp.StatusCode != http.StatusOK || isLoginPage(page) {
+		return fmt.Errorf("logging in as %s: %w", username, ErrNotLoggedIn)
+	}
+
+	return nil
+}
+
+// isLoginPage spots the HTML login page that ZwiftPower serves in place of data when there's no session
+func isLoginPage(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '<' {
+		return false
+	}
+
+	return bytes.Contains(body, []byte(`name="password"`)) ||
+		bytes.Contains(body, []byte("mode=login"))
+}
+
+// sessionJar is a cookie jar that also remembers the cookies as they were set, with their domains and expiry,
+// which the jar won't give back, so that they can be saved
+type sessionJar struct {
+	http.CookieJar
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie
+}
+
+// savedCookie is a cookie in a session file, with the URL that set it
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+func newSessionJar() (*sessionJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionJar{CookieJar: jar, cookies: map[string]savedCookie{}}, nil
+}
+
+func (j *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.CookieJar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	setBy := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, c := range cookies {
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := domain + ";" + c.Path + ";" + c.Name
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(j.cookies, key)
+			continue
+		}
+
+		// Max-Age counts from now, so it's kept as an expiry time instead
+		saved := *c
+		if saved.MaxAge > 0 {
+			saved.Expires, saved.MaxAge = now.Add(time.Duration(saved.MaxAge)*time.Second), 0
+		}
+		j.cookies[key] = savedCookie{URL: setBy, Cookie: &saved}
+	}
+}
+
+func (j *sessionJar) saved() []savedCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	saved := make([]savedCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		saved = append(saved, c)
+	}
+	sort.Slice(saved, func(i, k int) bool { return saved[i].URL+saved[i].Cookie.Name < saved[k].URL+saved[k].Cookie.Name })
+	return saved
+}
+
+// SaveSession writes the client's cookies to a file, with their domains and expiry, so the next run can use the
+// same session
+func (c *Client) SaveSession(filename string) error {
+	j, ok := c.jar.(*sessionJar)
+	if !ok {
+		return fmt.Errorf("client has no session to save")
+	}
+
+	data, err := json.Marshal(j.saved())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0600)
+}
+
+// LoadSession reads cookies saved by SaveSession into the client's cookie jar. Cookies that have expired since are
+// left out.
+func (c *Client) LoadSession(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var saved []savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("reading session from %s: %v", filename, err)
+	}
+
+	now := time.Now()
+	for _, s := range saved {
+		if s.Cookie == nil || (!s.Cookie.Expires.IsZero() && s.Cookie.Expires.Before(now)) {
+			continue
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return fmt.Errorf("reading session from %s: %v", filename, err)
+		}
+		c.jar.SetCookies(u, []*http.Cookie{s.Cookie})
+	}
+	return nil
+}
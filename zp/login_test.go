@@ -0,0 +1,105 @@
+package zp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const loginForm = `<html><body><form id="kc-form-login" action="/auth?session_code=abc&amp;execution=1" method="post">
+<input name="username"><input name="password" type="password"></form></body></html>`
+
+// loginServer pretends to be ZwiftPower, where team data needs a session cookie
+func loginServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ucp.php":
+			w.Write([]byte(loginForm))
+		case "/auth":
+			if r.URL.Query().Get("session_code") != "abc" || r.FormValue("username") != "rider" || r.FormValue("password") != "secret" {
+				w.Write([]byte(loginForm))
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "phpbb3_sid", Value: "s3ss10n", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: "phpbb3_k", Value: "k3y", Path: "/forum", MaxAge: 3600})
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/":
+			w.Write([]byte(`<html>Welcome back</html>`))
+		default:
+			if c, err := r.Cookie("phpbb3_sid"); err != nil || c.Value != "s3ss10n" {
+				w.Write([]byte(`<html><a href="/ucp.php?mode=login">Login</a></html>`))
+				return
+			}
+			w.Write([]byte(`{"data":[{"name":"Some name","zwid":98588}]}`))
+		}
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	srv := loginServer()
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	_, err = ImportZP(client, 2740)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Expected a login error before logging in, got %v", err)
+	}
+
+	err = client.Login("rider", "wrong")
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Expected a login error for a bad password, got %v", err)
+	}
+
+	err = client.Login("rider", "secret")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	riders, err := ImportZP(client, 2740)
+	if err != nil || len(riders) != 1 {
+		t.Fatalf("Got %v, %v after logging in", riders, err)
+	}
+
+	// A new client can pick up the saved session
+	session := filepath.Join(t.TempDir(), "session.json")
+	if err := client.SaveSession(session); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+
+	// It keeps cookies for other paths, with their expiry
+	data, err := ioutil.ReadFile(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[0].Cookie.Name != "phpbb3_k" || saved[0].Cookie.Expires.Before(time.Now()) {
+		t.Errorf("Unexpected saved session %s", data)
+	}
+
+	client2, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client2.BaseURL = srv.URL
+	if err := client2.LoadSession(session); err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+
+	riders, err = ImportZP(client2, 2740)
+	if err != nil || len(riders) != 1 {
+		t.Errorf("Got %v, %v with the saved session", riders, err)
+	}
+}
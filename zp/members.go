@@ -0,0 +1,43 @@
+package zp
+
+import "time"
+
+// Member is a rider in a club's member list
+type Member struct {
+	Zwid int    `json:"zwid"`
+	Name string `json:"name"`
+}
+
+// Members is a club's member list as of an import, and how it changed since the import before
+type Members struct {
+	ClubID  int       `json:"club_id"`
+	Updated time.Time `json:"updated"`
+	Members []Member  `json:"members"`
+	Joined  []Member  `json:"joined"`
+	Left    []Member  `json:"left"`
+}
+
+// TrackMembers works out who's joined and left the club since the previous member list. If there isn't a
+// previous list, nobody counts as having joined.
+func TrackMembers(prev *Members, clubID int, riders []Rider, now time.Time) Members {
+	m := Members{ClubID: clubID, Updated: now, Members: members(riders)}
+	if prev == nil {
+		return m
+	}
+
+	old := make([]Rider, len(prev.Members))
+	for i, p := range prev.Members {
+		old[i] = Rider{Zwid: p.Zwid, Name: p.Name}
+	}
+	joined, left := Membership(old, riders)
+	m.Joined, m.Left = members(joined), members(left)
+	return m
+}
+
+func members(riders []Rider) []Member {
+	m := make([]Member, len(riders))
+	for i, r := range riders {
+		m[i] = Member{Zwid: r.Zwid, Name: r.Name}
+	}
+	return m
+}
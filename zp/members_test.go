@@ -0,0 +1,25 @@
+package zp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackMembers(t *testing.T) {
+	now := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	riders := []Rider{{Zwid: 1, Name: "One"}, {Zwid: 2, Name: "Two"}}
+
+	first := TrackMembers(nil, 2672, riders, now)
+	if len(first.Joined) != 0 || len(first.Left) != 0 || len(first.Members) != 2 {
+		t.Errorf("First run shouldn't have changes: %+v", first)
+	}
+
+	second := TrackMembers(&first, 2672, []Rider{{Zwid: 2, Name: "Two"}, {Zwid: 3, Name: "Three"}}, now)
+	if !reflect.DeepEqual(second.Joined, []Member{{Zwid: 3, Name: "Three"}}) {
+		t.Errorf("Joined: got %v", second.Joined)
+	}
+	if !reflect.DeepEqual(second.Left, []Member{{Zwid: 1, Name: "One"}}) {
+		t.Errorf("Left: got %v", second.Left)
+	}
+}
@@ -0,0 +1,83 @@
+package zp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxThrottleRetries is how many times we'll retry a request that ZwiftPower rejects with 429 Too Many Requests
+const maxThrottleRetries = 5
+
+// defaultRetryAfter is how long we back off after a 429 that doesn't say how long to wait
+const defaultRetryAfter = 5 * time.Second
+
+// limiter spaces out requests, and holds them all back when the server asks us to slow down
+type limiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until it's OK to make another request, allowing rps requests per second. Zero rps means no limit.
+func (l *limiter) wait(rps float64) {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	if rps > 0 {
+		l.next = start.Add(time.Duration(float64(time.Second) / rps))
+	} else {
+		l.next = start
+	}
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+// pause stops any request starting before until
+func (l *limiter) pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.next) {
+		l.next = until
+	}
+}
+
+// retryAfter works out how long a response's Retry-After header is asking us to wait.
+// It can either be a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return defaultRetryAfter
+	}
+
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+
+	return defaultRetryAfter
+}
+
+// isTransient says whether a request that failed with err is worth retrying
+func isTransient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
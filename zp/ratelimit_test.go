@@ -0,0 +1,134 @@
+package zp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTooManyRequests(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"data":[{"name":"Some name","zwid":98588}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	riders, err := ImportZP(client, 2740)
+	if err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if len(riders) != 1 || requests != 3 {
+		t.Errorf("Got %d riders after %d requests", len(riders), requests)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			// Drop the connection without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			w.Write([]byte(`{"data":[{"name":"Some name","zwid":98588}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.Backoff = time.Millisecond
+
+	riders, err := ImportZP(client, 2740)
+	if err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if len(riders) != 1 || requests != 3 {
+		t.Errorf("Got %d riders after %d requests", len(riders), requests)
+	}
+
+	// Give up once the retries run out
+	requests = 0
+	client.Retries = 1
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err = ImportZP(client, 2740)
+	if err == nil || requests != 2 {
+		t.Errorf("Got error %v after %d requests", err, requests)
+	}
+}
+
+func TestRequestsPerSecond(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.RequestsPerSecond = 50
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := ImportZP(client, 2740); err != nil {
+			t.Fatalf("ImportZP: %v", err)
+		}
+	}
+
+	// The first request goes straight away, then one every 20ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Six requests took %v, expected at least 100ms", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{header: "", expected: defaultRetryAfter},
+		{header: "7", expected: 7 * time.Second},
+		{header: "soon", expected: defaultRetryAfter},
+	}
+
+	for i, c := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		if c.header != "" {
+			resp.Header.Set("Retry-After", c.header)
+		}
+		if got := retryAfter(resp); got != c.expected {
+			t.Errorf("Case %d: got %v expected %v", i, got, c.expected)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if got := retryAfter(resp); got < 58*time.Second || got > time.Minute {
+		t.Errorf("Got %v for an HTTP date a minute away", got)
+	}
+}
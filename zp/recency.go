@@ -0,0 +1,142 @@
+package zp
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecencyBucket groups how long ago something happened, for describing it in words
+type RecencyBucket int
+
+// Recency buckets, most recent first
+const (
+	// NoEvent is for riders with no events at all
+	NoEvent RecencyBucket = iota
+	// ThisMonth is the same calendar month as the report
+	ThisMonth
+	// LastMonth is the calendar month before
+	LastMonth
+	// MonthsAgo is two or more calendar months before, but within a year
+	MonthsAgo
+	// OverAYear is more than a year before
+	OverAYear
+)
+
+var bucketNames = map[RecencyBucket]string{
+	NoEvent:   "no_event",
+	ThisMonth: "this_month",
+	LastMonth: "last_month",
+	MonthsAgo: "months_ago",
+	OverAYear: "over_a_year",
+}
+
+// MarshalText gives the bucket's name, such as "this_month", for structured output
+func (b RecencyBucket) MarshalText() ([]byte, error) {
+	name, ok := bucketNames[b]
+	if !ok {
+		return nil, fmt.Errorf("unknown recency bucket %d", b)
+	}
+	return []byte(name), nil
+}
+
+// Recency is how long before a report a rider's latest event was
+type Recency struct {
+	// Months is the number of calendar months between, so the 31st of one month is one month before the 1st of the next
+	Months int `json:"months"`
+	// Days is the number of whole days between
+	Days   int           `json:"days"`
+	Bucket RecencyBucket `json:"bucket"`
+}
+
+// RecencyOf works out how recent t was, as of a particular time. It's NoEvent if t is zero.
+func RecencyOf(t time.Time, asOf time.Time) Recency {
+	if t.IsZero() {
+		return Recency{Bucket: NoEvent}
+	}
+
+	// Compare calendar dates in the same location, so the months and days don't depend on time zones
+	t = t.In(asOf.Location())
+	r := Recency{
+		Months: (asOf.Year()-t.Year())*12 + int(asOf.Month()-t.Month()),
+		Days:   int(asOf.Sub(t).Hours() / 24),
+	}
+	if r.Months < 0 {
+		r.Months = 0
+	}
+	if r.Days < 0 {
+		r.Days = 0
+	}
+
+	switch {
+	case t.Before(asOf.AddDate(-1, 0, 0)):
+		r.Bucket = OverAYear
+	case r.Months == 0:
+		r.Bucket = ThisMonth
+	case r.Months == 1:
+		r.Bucket = LastMonth
+	default:
+		r.Bucket = MonthsAgo
+	}
+	return r
+}
+
+// Language picks the translations for Recency.Format
+type Language string
+
+// Languages we have translations for
+const (
+	English Language = "en"
+	Dutch   Language = "nl"
+	German  Language = "de"
+)
+
+// recencyText has the words for each bucket. MonthsAgo gets the number of months.
+var recencyText = map[Language]map[RecencyBucket]string{
+	English: {
+		NoEvent:   "No latest event",
+		ThisMonth: "This month",
+		LastMonth: "Last month",
+		MonthsAgo: "%d months ago",
+		OverAYear: "Over a year ago",
+	},
+	Dutch: {
+		NoEvent:   "Geen laatste evenement",
+		ThisMonth: "Deze maand",
+		LastMonth: "Vorige maand",
+		MonthsAgo: "%d maanden geleden",
+		OverAYear: "Meer dan een jaar geleden",
+	},
+	German: {
+		NoEvent:   "Keine letzte Veranstaltung",
+		ThisMonth: "Diesen Monat",
+		LastMonth: "Letzten Monat",
+		MonthsAgo: "Vor %d Monaten",
+		OverAYear: "Vor über einem Jahr",
+	},
+}
+
+// ParseLanguage checks that we have translations for a language code such as "nl". Empty means English.
+func ParseLanguage(s string) (Language, error) {
+	if s == "" {
+		return English, nil
+	}
+
+	lang := Language(s)
+	if _, ok := recencyText[lang]; !ok {
+		return "", fmt.Errorf("no translations for language %q", s)
+	}
+	return lang, nil
+}
+
+// Format describes the recency in words, in the given language. Unknown languages get English.
+func (r Recency) Format(lang Language) string {
+	text, ok := recencyText[lang]
+	if !ok {
+		text = recencyText[English]
+	}
+
+	if r.Bucket == MonthsAgo {
+		return fmt.Sprintf(text[MonthsAgo], r.Months)
+	}
+	return text[r.Bucket]
+}
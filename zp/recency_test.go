@@ -0,0 +1,65 @@
+package zp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecencyOf(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		t, asOf  time.Time
+		expected Recency
+	}{
+		{asOf: date(2021, 1, 1), expected: Recency{Bucket: NoEvent}},
+		{t: date(2021, 1, 1), asOf: date(2021, 1, 31), expected: Recency{Months: 0, Days: 30, Bucket: ThisMonth}},
+		{t: date(2020, 12, 31), asOf: date(2021, 1, 1), expected: Recency{Months: 1, Days: 1, Bucket: LastMonth}},
+		{t: date(2020, 11, 30), asOf: date(2021, 1, 1), expected: Recency{Months: 2, Days: 32, Bucket: MonthsAgo}},
+		{t: date(2020, 5, 21), asOf: date(2021, 5, 20), expected: Recency{Months: 12, Days: 364, Bucket: MonthsAgo}},
+		{t: date(2020, 5, 19), asOf: date(2021, 5, 20), expected: Recency{Months: 12, Days: 366, Bucket: OverAYear}},
+		{t: date(2019, 6, 1), asOf: date(2021, 5, 20), expected: Recency{Months: 23, Days: 719, Bucket: OverAYear}},
+		// Dates are compared in the report's time zone
+		{t: time.Date(2021, 2, 28, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60)), asOf: date(2021, 3, 1),
+			expected: Recency{Months: 0, Days: 0, Bucket: ThisMonth}},
+	}
+
+	for i, c := range cases {
+		r := RecencyOf(c.t, c.asOf)
+		if r != c.expected {
+			t.Errorf("Case %d: got %+v expected %+v", i, r, c.expected)
+		}
+	}
+}
+
+func TestRecencyFormat(t *testing.T) {
+	cases := []struct {
+		r        Recency
+		lang     Language
+		expected string
+	}{
+		{r: Recency{Bucket: NoEvent}, lang: English, expected: "No latest event"},
+		{r: Recency{Bucket: ThisMonth}, lang: Dutch, expected: "Deze maand"},
+		{r: Recency{Bucket: LastMonth}, lang: German, expected: "Letzten Monat"},
+		{r: Recency{Months: 4, Bucket: MonthsAgo}, lang: English, expected: "4 months ago"},
+		{r: Recency{Months: 4, Bucket: MonthsAgo}, lang: Dutch, expected: "4 maanden geleden"},
+		{r: Recency{Months: 4, Bucket: MonthsAgo}, lang: German, expected: "Vor 4 Monaten"},
+		{r: Recency{Months: 14, Bucket: OverAYear}, lang: German, expected: "Vor über einem Jahr"},
+		{r: Recency{Bucket: ThisMonth}, lang: "fr", expected: "This month"},
+	}
+
+	for i, c := range cases {
+		if got := c.r.Format(c.lang); got != c.expected {
+			t.Errorf("Case %d: got %s expected %s", i, got, c.expected)
+		}
+	}
+
+	if _, err := ParseLanguage("fr"); err == nil {
+		t.Errorf("Expected an error for a language without translations")
+	}
+	if lang, err := ParseLanguage(""); err != nil || lang != English {
+		t.Errorf("Got %s, %v for the default language", lang, err)
+	}
+}
@@ -0,0 +1,196 @@
+package zp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column is one column of output: the name to select it by, its header, where its value comes from, and how to
+// turn that value into text
+type Column struct {
+	Name   string
+	Header string
+	Value  func(r Rider) interface{}
+	Format Formatter
+}
+
+// Formatter turns a column's value into text, in the given language
+type Formatter func(v interface{}, lang Language) string
+
+// Schema is the list of columns to output, shared by all the writers so that headers and rows always match
+type Schema struct {
+	Columns []Column
+	Lang    Language
+}
+
+// Header gives the header row
+func (s Schema) Header() []string {
+	header := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		header[i] = c.Header
+	}
+	return header
+}
+
+// Row gives the rider's row
+func (s Schema) Row(r Rider) []string {
+	row := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		row[i] = c.Format(c.Value(r), s.Lang)
+	}
+	return row
+}
+
+func formatText(v interface{}, lang Language) string {
+	return fmt.Sprint(v)
+}
+
+func formatWkg(v interface{}, lang Language) string {
+	return strconv.FormatFloat(v.(float64), 'f', 1, 64)
+}
+
+func formatDate(v interface{}, lang Language) string {
+	return v.(time.Time).Format("2006-01-02")
+}
+
+func formatRecency(v interface{}, lang Language) string {
+	return v.(Recency).Format(lang)
+}
+
+// DefaultColumns are the columns we've always output, in order
+var DefaultColumns = []Column{
+	{"name", "Name", func(r Rider) interface{} { return r.Name }, formatText},
+	{"zwid", "Zwid", func(r Rider) interface{} { return r.Zwid }, formatText},
+	{"latest_event_date", "Latest event date", func(r Rider) interface{} { return r.LatestEventDate }, formatDate},
+	{"last_seen", "Last seen", func(r Rider) interface{} { return r.Recency() }, formatRecency},
+	{"latest_event", "Latest event", func(r Rider) interface{} { return r.LatestEvent }, formatText},
+	{"rides", "Rides 365 days", func(r Rider) interface{} { return r.Rides }, formatText},
+	{"profile", "Profile", func(r Rider) interface{} { return ProfileURL(r.Zwid) }, formatText},
+	{"ftp30", "FTP 30 days", func(r Rider) interface{} { return r.Ftp30 }, formatWkg},
+	{"ftp90", "FTP 90 days", func(r Rider) interface{} { return r.Ftp90 }, formatWkg},
+	{"races30", "Races 30 days", func(r Rider) interface{} { return r.Races30 }, formatText},
+	{"races90", "Races 90 days", func(r Rider) interface{} { return r.Races90 }, formatText},
+	{"races", "Races 365 days", func(r Rider) interface{} { return r.Races }, formatText},
+	{"latest_race", "Latest race", func(r Rider) interface{} { return r.LatestRace }, formatText},
+	{"latest_race_date", "Latest race date", func(r Rider) interface{} { return r.LatestRaceDate }, formatDate},
+}
+
+// extraColumns can be selected by name, but aren't output by default
+var extraColumns = []Column{
+	{"ftp60", "FTP 60 days", func(r Rider) interface{} { return r.Ftp60 }, formatWkg},
+	{"latest_race_avg_wkg", "Latest race avg W/kg", func(r Rider) interface{} { return r.LatestRaceAvgWkg }, formatWkg},
+	{"latest_race_wkg_ftp", "Latest race FTP W/kg", func(r Rider) interface{} { return r.LatestRaceWkgFtp }, formatWkg},
+	{"category", "Category", func(r Rider) interface{} { return string(r.Category.Category) }, formatText},
+	{"women_category", "Women's category", func(r Rider) interface{} { return string(r.Category.WomenCategory) }, formatText},
+	{"category_wkg", "Category FTP W/kg", func(r Rider) interface{} { return r.Category.Wkg }, formatWkg},
+	{"upgrade_risk", "Upgrade risk", func(r Rider) interface{} { return r.Category.UpgradeRisk }, formatText},
+}
+
+// WindowColumns are the columns for stats over a window, named like ftp_28d, races_28d and rides_28d
+func WindowColumns(w Window) []Column {
+	stats := func(r Rider) WindowStats {
+		for _, s := range r.Windows {
+			if s.Window.Name == w.Name {
+				return s
+			}
+		}
+		return WindowStats{Window: w}
+	}
+
+	return []Column{
+		{"ftp_" + w.Name, "FTP " + w.Name, func(r Rider) interface{} { return stats(r).Ftp }, formatWkg},
+		{"races_" + w.Name, "Races " + w.Name, func(r Rider) interface{} { return stats(r).Races }, formatText},
+		{"rides_" + w.Name, "Rides " + w.Name, func(r Rider) interface{} { return stats(r).Rides }, formatText},
+	}
+}
+
+// DefaultSchema is the default columns, followed by FTP and races for each of the windows
+func DefaultSchema(windows []Window, lang Language) Schema {
+	columns := append([]Column{}, DefaultColumns...)
+	for _, w := range windows {
+		columns = append(columns, WindowColumns(w)[:2]...)
+	}
+	return Schema{Columns: columns, Lang: lang}
+}
+
+// ParseSchema picks columns by name from a comma-separated list, such as "name,zwid,ftp30,ftp_28d".
+// Window columns can be used for any of the windows. An empty list gives the DefaultSchema.
+func ParseSchema(names string, windows []Window, lang Language) (Schema, error) {
+	if strings.TrimSpace(names) == "" {
+		return DefaultSchema(windows, lang), nil
+	}
+
+	available := map[string]Column{}
+	all := append(append([]Column{}, DefaultColumns...), extraColumns...)
+	for _, w := range windows {
+		all = append(all, WindowColumns(w)...)
+	}
+	for _, c := range all {
+		available[c.Name] = c
+	}
+
+	schema := Schema{Lang: lang}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := available[name]
+		if !ok {
+			return Schema{}, fmt.Errorf("unknown column %q", name)
+		}
+		schema.Columns = append(schema.Columns, c)
+	}
+	return schema, nil
+}
+
+// Record is a rider's values for each of the schema's columns, keeping their types rather than formatting them as
+// text. It marshals to a JSON object with the columns in order.
+type Record struct {
+	Columns []Column
+	Values  []interface{}
+}
+
+// Record gives the rider's structured record
+func (s Schema) Record(r Rider) Record {
+	rec := Record{Columns: s.Columns, Values: make([]interface{}, len(s.Columns))}
+	for i, c := range s.Columns {
+		rec.Values[i] = c.Value(r)
+	}
+	return rec
+}
+
+// MarshalJSON writes the record as an object keyed by column name. Missing dates are null.
+func (rec Record) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, c := range rec.Columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, err := json.Marshal(c.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		v := rec.Values[i]
+		if t, ok := v.(time.Time); ok && t.IsZero() {
+			v = nil
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling %s: %v", c.Name, err)
+		}
+
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+package zp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchema(t *testing.T) {
+	windows := []Window{{Name: "28d", Days: 28}}
+	r := Rider{
+		Name:            "Liz Rice",
+		Zwid:            98588,
+		AsOf:            time.Date(2021, 5, 20, 0, 0, 0, 0, time.UTC),
+		LatestEventDate: time.Date(2021, 4, 15, 0, 0, 0, 0, time.UTC),
+		Ftp60:           2.75,
+		Windows:         []WindowStats{{Window: windows[0], Rides: 4, Races: 3, Ftp: 2.81}},
+	}
+
+	cases := []struct {
+		columns string
+		lang    Language
+		header  []string
+		row     []string
+	}{
+		{
+			columns: "name, zwid,last_seen,ftp60",
+			lang:    Dutch,
+			header:  []string{"Name", "Zwid", "Last seen", "FTP 60 days"},
+			row:     []string{"Liz Rice", "98588", "Vorige maand", "2.8"},
+		},
+		{
+			columns: "zwid,ftp_28d,races_28d,rides_28d,latest_event_date",
+			header:  []string{"Zwid", "FTP 28d", "Races 28d", "Rides 28d", "Latest event date"},
+			row:     []string{"98588", "2.8", "3", "4", "2021-04-15"},
+		},
+	}
+
+	for i, c := range cases {
+		s, err := ParseSchema(c.columns, windows, c.lang)
+		if err != nil {
+			t.Fatalf("Case %d: %v", i, err)
+		}
+		if h := s.Header(); !reflect.DeepEqual(h, c.header) {
+			t.Errorf("Case %d: got header %v expected %v", i, h, c.header)
+		}
+		if row := s.Row(r); !reflect.DeepEqual(row, c.row) {
+			t.Errorf("Case %d: got row %v expected %v", i, row, c.row)
+		}
+	}
+
+	for _, bad := range []string{"name,nope", "ftp_7d"} {
+		if _, err := ParseSchema(bad, windows, English); err == nil {
+			t.Errorf("Expected an error for columns %q", bad)
+		}
+	}
+
+	// No columns means the default ones, with FTP and races for each window
+	s, err := ParseSchema("", windows, English)
+	if err != nil {
+		t.Fatalf("ParseSchema: %v", err)
+	}
+	if len(s.Columns) != len(DefaultColumns)+2 || len(s.Row(r)) != len(s.Header()) {
+		t.Errorf("Got %d columns", len(s.Columns))
+	}
+	if !reflect.DeepEqual(s.Row(r)[:14], r.Strings()[:14]) {
+		t.Errorf("Default schema doesn't match Rider.Strings: %v", s.Row(r))
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	s, err := ParseSchema("zwid,name,ftp30,last_seen,latest_event_date,latest_race_date", nil, English)
+	if err != nil {
+		t.Fatalf("ParseSchema: %v", err)
+	}
+
+	r := Rider{
+		Name:            "Liz Rice",
+		Zwid:            98588,
+		Ftp30:           2.5,
+		AsOf:            time.Date(2021, 5, 20, 0, 0, 0, 0, time.UTC),
+		LatestEventDate: time.Date(2021, 5, 15, 18, 0, 0, 0, time.UTC),
+	}
+	data, err := json.Marshal(s.Record(r))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	expected := `{"zwid":98588,"name":"Liz Rice","ftp30":2.5,"last_seen":{"months":0,"days":4,"bucket":"this_month"},` +
+		`"latest_event_date":"2021-05-15T18:00:00Z","latest_race_date":null}`
+	if string(data) != expected {
+		t.Errorf("Got %s\nexpected %s", data, expected)
+	}
+}
+
+func TestWindowColumnsWithoutStats(t *testing.T) {
+	// Window stats for a rider without them come out as zero
+	c := WindowColumns(Window{Name: "7d", Days: 7})
+	if v := c[0].Format(c[0].Value(Rider{}), English); v != "0.0" {
+		t.Errorf("Got %s", v)
+	}
+}
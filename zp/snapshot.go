@@ -0,0 +1,95 @@
+package zp
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsers read the columns that a snapshot can be compared on back into a Rider
+var parsers = map[string]func(r *Rider, s string) error{
+	"name": func(r *Rider, s string) error { r.Name = s; return nil },
+	"zwid": func(r *Rider, s string) (err error) { r.Zwid, err = strconv.Atoi(s); return err },
+	"latest_event_date": func(r *Rider, s string) (err error) {
+		r.LatestEventDate, err = parseDate(s)
+		return err
+	},
+	"latest_event": func(r *Rider, s string) error { r.LatestEvent = s; return nil },
+	"rides":        func(r *Rider, s string) (err error) { r.Rides, err = strconv.Atoi(s); return err },
+	"ftp30":        func(r *Rider, s string) (err error) { r.Ftp30, err = strconv.ParseFloat(s, 64); return err },
+	"ftp60":        func(r *Rider, s string) (err error) { r.Ftp60, err = strconv.ParseFloat(s, 64); return err },
+	"ftp90":        func(r *Rider, s string) (err error) { r.Ftp90, err = strconv.ParseFloat(s, 64); return err },
+	"races30":      func(r *Rider, s string) (err error) { r.Races30, err = strconv.Atoi(s); return err },
+	"races90":      func(r *Rider, s string) (err error) { r.Races90, err = strconv.Atoi(s); return err },
+	"races":        func(r *Rider, s string) (err error) { r.Races, err = strconv.Atoi(s); return err },
+	"latest_race":  func(r *Rider, s string) error { r.LatestRace = s; return nil },
+	"latest_race_date": func(r *Rider, s string) (err error) {
+		r.LatestRaceDate, err = parseDate(s)
+		return err
+	},
+}
+
+// parseDate reads a date as formatDate writes it. ZwiftPower's zero date comes out as 0001-01-01.
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+// ReadSnapshot reads riders back from CSV output, such as an earlier results.csv. The first row must be a header;
+// columns can be named by their header (as we write them) or their column name. Columns that can't be read back,
+// such as "Last seen", are ignored. There must be a Zwid column.
+func ReadSnapshot(r io.Reader) ([]Rider, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+
+	names := map[string]string{}
+	all := append(append([]Column{}, DefaultColumns...), extraColumns...)
+	for _, c := range all {
+		names[strings.ToLower(c.Header)] = c.Name
+		names[c.Name] = c.Name
+	}
+
+	var hasZwid bool
+	columns := make([]string, len(header))
+	for i, h := range header {
+		columns[i] = names[strings.ToLower(strings.TrimSpace(h))]
+		if columns[i] == "zwid" {
+			hasZwid = true
+		}
+	}
+	if !hasZwid {
+		return nil, fmt.Errorf("no Zwid column in header %v", header)
+	}
+
+	var riders []Rider
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return riders, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rider Rider
+		for i, value := range row {
+			if i >= len(columns) || parsers[columns[i]] == nil || value == "" {
+				continue
+			}
+			if err := parsers[columns[i]](&rider, value); err != nil {
+				return nil, fmt.Errorf("line %d, column %s: %v", line, header[i], err)
+			}
+		}
+		riders = append(riders, rider)
+	}
+}
@@ -0,0 +1,237 @@
+package zp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State remembers what the last import saw, so the next one can skip riders whose data hasn't changed.
+// It lives in a directory: state.json (or state_<club>.json), plus each rider's event data laid out as
+// profile/<id>_all.json.
+type State struct {
+	dir    string
+	file   string
+	mu     sync.Mutex
+	riders map[int]RiderState
+}
+
+// RiderState is what we know about the last time we fetched a rider's data
+type RiderState struct {
+	// Fingerprint is the rider's club list entry when we fetched their data
+	Fingerprint string `json:"fingerprint"`
+	// ETag and LastModified are from ZwiftPower's response, for conditional requests
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+const stateFile = "state.json"
+
+// OpenState reads the state saved in dir, or starts an empty state if there isn't one yet
+func OpenState(dir string) (*State, error) {
+	return openState(dir, stateFile, stateFile)
+}
+
+// OpenClubState reads the club's state saved in dir, so imports of different clubs can share the directory
+// without overwriting each other's state. If the club has none yet, it starts from the shared state.json.
+func OpenClubState(dir string, clubID int) (*State, error) {
+	return openState(dir, fmt.Sprintf("state_%d.json", clubID), stateFile)
+}
+
+func openState(dir, file, fallback string) (*State, error) {
+	s := &State{dir: dir, file: file, riders: map[int]RiderState{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if os.IsNotExist(err) && fallback != file {
+		data, err = ioutil.ReadFile(filepath.Join(dir, fallback))
+	}
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading import state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &s.riders); err != nil {
+		return nil, fmt.Errorf("parsing import state: %v", err)
+	}
+	return s, nil
+}
+
+// Save writes the state back to its directory
+func (s *State) Save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.riders, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("saving import state: %v", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.dir, s.file), data); err != nil {
+		return fmt.Errorf("saving import state: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file of its own first, then renames it, so neither a crash nor another
+// import writing the same file can leave half a file behind
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Rider gives what we know about the rider from the last import, and their saved event data.
+// ok is false if we don't have both.
+func (s *State) Rider(zwid int) (rs RiderState, data []byte, ok bool) {
+	s.mu.Lock()
+	rs, ok = s.riders[zwid]
+	s.mu.Unlock()
+	if !ok {
+		return rs, nil, false
+	}
+
+	data, err := ioutil.ReadFile(s.profileFile(zwid))
+	if err != nil {
+		return rs, nil, false
+	}
+	return rs, data, true
+}
+
+// SetRider records the rider's state, along with their event data if it's changed
+func (s *State) SetRider(zwid int, rs RiderState, data []byte) error {
+	if data != nil {
+		if err := os.MkdirAll(filepath.Dir(s.profileFile(zwid)), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.profileFile(zwid), data); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.riders[zwid] = rs
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *State) profileFile(zwid int) string {
+	return filepath.Join(s.dir, "profile", fmt.Sprintf("%d_all.json", zwid))
+}
+
+// importRider imports the rider's data, using the saved state where we can. If the rider's club list entry is
+// the same as last time (and the data isn't older than RefreshAfter), we don't ask ZwiftPower at all. Otherwise
+// we make a conditional request, so that ZwiftPower only needs to send the data if it's changed.
+// Either way, the stats are worked out afresh, as of the Client's AsOf time.
+func (im *Importer) importRider(rider Rider) (RiderProfile, error) {
+	client := im.Client
+	if im.State == nil {
+		return ImportRiderEvents(client, rider.Zwid)
+	}
+
+	prev, saved, ok := im.State.Rider(rider.Zwid)
+	fresh := im.RefreshAfter == 0 || time.Since(prev.FetchedAt) < im.RefreshAfter
+	if ok && fresh && rider.Fingerprint != "" && prev.Fingerprint == rider.Fingerprint {
+		log.Printf("Rider %d unchanged since last import", rider.Zwid)
+		return profileFromJSON(client, rider.Zwid, saved)
+	}
+
+	url := client.url(fmt.Sprintf("/cache3/profile/%d_all.json", rider.Zwid))
+	if data, ok := client.fromCache(url); ok {
+		return profileFromJSON(client, rider.Zwid, data)
+	}
+
+	header := http.Header{}
+	if ok {
+		if prev.ETag != "" {
+			header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	// As in ImportRiderEvents, hitting the profile page first seems to refresh ZwiftPower's cache
+	log.Printf("ImportRider(%d)", rider.Zwid)
+	if resp, err := client.get(client.url(fmt.Sprintf("/profile.php?z=%d", rider.Zwid))); err == nil {
+		resp.Body.Close()
+	}
+
+	resp, err := client.getWithHeader(url, header)
+	if err != nil {
+		return RiderProfile{Rider: Rider{Zwid: rider.Zwid}}, err
+	}
+	defer resp.Body.Close()
+
+	rs := RiderState{
+		Fingerprint:  rider.Fingerprint,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+
+	var data []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		log.Printf("Rider %d not modified", rider.Zwid)
+		if rs.ETag == "" {
+			rs.ETag = prev.ETag
+		}
+		if rs.LastModified == "" {
+			rs.LastModified = prev.LastModified
+		}
+		data = saved
+	case resp.StatusCode == http.StatusOK:
+		data, err = ioutil.ReadAll(resp.Body)
+		if err == nil && isLoginPage(data) {
+			err = fmt.Errorf("getting %s: %w", url, ErrNotLoggedIn)
+		}
+		if err != nil {
+			return RiderProfile{Rider: Rider{Zwid: rider.Zwid}}, err
+		}
+	default:
+		return RiderProfile{Rider: Rider{Zwid: rider.Zwid}}, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, url)
+	}
+
+	profile, err := profileFromJSON(client, rider.Zwid, data)
+	if err != nil {
+		return profile, err
+	}
+
+	// Only remember data we could parse, so a bad response gets fetched again next time
+	var changed []byte
+	if resp.StatusCode == http.StatusOK {
+		changed = data
+	}
+	client.toCache(url, data)
+	if err := im.State.SetRider(rider.Zwid, rs, changed); err != nil {
+		log.Printf("Saving state for rider %d: %v", rider.Zwid, err)
+	}
+	return profile, nil
+}
@@ -0,0 +1,150 @@
+package zp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestImportRidersIncremental(t *testing.T) {
+	var fetched, notModified int32
+	rank := "580.36"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/cache3/teams/2672_riders.json":
+			fmt.Fprintf(w, `{"data":[{"zwid":1,"name":"One","rank":"%s"},{"zwid":2,"name":"Two","rank":"640.12"}]}`, rank)
+		case strings.HasPrefix(r.URL.Path, "/cache3/profile/"):
+			var id int
+			fmt.Sscanf(r.URL.Path, "/cache3/profile/%d_all.json", &id)
+			etag := fmt.Sprintf(`"rider-%d"`, id)
+			if r.Header.Get("If-None-Match") == etag {
+				atomic.AddInt32(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			atomic.AddInt32(&fetched, 1)
+			w.Header().Set("ETag", etag)
+			fmt.Fprintf(w, `{"data":[{"event_title":"Race %d","f_t":"TYPE_RACE","event_date":1601736300,"wkg_ftp":["2.5",0]}]}`, id)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	dir := t.TempDir()
+
+	run := func() []string {
+		state, err := OpenState(dir)
+		if err != nil {
+			t.Fatalf("OpenState: %v", err)
+		}
+		riders, err := ImportZP(client, 2672)
+		if err != nil {
+			t.Fatalf("ImportZP: %v", err)
+		}
+
+		im := Importer{Client: client, Workers: 2, State: state}
+		var got []string
+		err = im.ImportRiders(riders, func(i int, profile RiderProfile, err error) error {
+			if err != nil {
+				t.Errorf("Rider %d: %v", i, err)
+			}
+			got = append(got, fmt.Sprintf("%s: %s %.1f", profile.Rider.Name, profile.Rider.LatestRace, profile.Rider.Ftp90))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ImportRiders: %v", err)
+		}
+		if err := state.Save(); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		return got
+	}
+
+	first := run()
+	if fetched != 2 {
+		t.Errorf("First import fetched %d riders, want 2", fetched)
+	}
+
+	// Nothing's changed in the club list, so there's no need to ask for any riders' data
+	second := run()
+	if fetched != 2 || notModified != 0 {
+		t.Errorf("Second import fetched %d and checked %d riders, want none", fetched-2, notModified)
+	}
+	if strings.Join(first, "\n") != strings.Join(second, "\n") {
+		t.Errorf("Second import got %v, want %v", second, first)
+	}
+
+	// Rider One's entry has changed, so we check with a conditional request
+	rank = "590.00"
+	third := run()
+	if fetched != 2 || notModified != 1 {
+		t.Errorf("Third import fetched %d and checked %d riders, want 0 and 1", fetched-2, notModified)
+	}
+	if strings.Join(first, "\n") != strings.Join(third, "\n") {
+		t.Errorf("Third import got %v, want %v", third, first)
+	}
+}
+
+func TestImportZPFingerprint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"zwid":1,"name":"One","rank":"1"},{"zwid":2,"name":"Two","rank":"1"}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	riders, err := ImportZP(client, 1)
+	if err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if riders[0].Fingerprint == "" || riders[0].Fingerprint == riders[1].Fingerprint {
+		t.Errorf("Fingerprints should differ: %q, %q", riders[0].Fingerprint, riders[1].Fingerprint)
+	}
+}
+
+func TestClubState(t *testing.T) {
+	dir := t.TempDir()
+
+	shared, err := OpenState(dir)
+	if err != nil {
+		t.Fatalf("OpenState: %v", err)
+	}
+	shared.SetRider(1, RiderState{Fingerprint: "shared"}, nil)
+	if err := shared.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// A club without its own state yet starts from the shared one, but saves to its own file
+	club, err := OpenClubState(dir, 2672)
+	if err != nil {
+		t.Fatalf("OpenClubState: %v", err)
+	}
+	if rs := club.riders[1]; rs.Fingerprint != "shared" {
+		t.Errorf("Club state didn't start from the shared state: %+v", club.riders)
+	}
+	club.SetRider(1, RiderState{Fingerprint: "2672"}, nil)
+	if err := club.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	for clubID, want := range map[int]string{2672: "2672", 2740: "shared"} {
+		s, err := OpenClubState(dir, clubID)
+		if err != nil {
+			t.Fatalf("OpenClubState: %v", err)
+		}
+		if got := s.riders[1].Fingerprint; got != want {
+			t.Errorf("Club %d: got fingerprint %q, want %q", clubID, got, want)
+		}
+	}
+}
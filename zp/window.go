@@ -0,0 +1,120 @@
+package zp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a period leading up to the time of a report, that we gather a rider's stats over
+type Window struct {
+	Name string
+	// Days counts back this many days
+	Days int
+	// Calendar is "month" or "year" for the calendar month or year so far
+	Calendar string
+	// Since counts from a fixed date, such as the start of a racing season
+	Since time.Time
+}
+
+// DefaultWindows are the ones behind Rider's Ftp30/60/90, Races30/90 and Rides/Races fields
+var DefaultWindows = []Window{
+	{Name: "30d", Days: 30},
+	{Name: "60d", Days: 60},
+	{Name: "90d", Days: 90},
+	{Name: "365d", Days: 365},
+}
+
+// WindowStats are a rider's stats over one window
+type WindowStats struct {
+	Window Window
+	Rides  int
+	Races  int
+	// Ftp is the best wkg_ftp in the window
+	Ftp float64
+}
+
+// ParseWindow understands "28d" for a number of days, "month" for the calendar month so far, "year"
+// for the calendar year so far, and "season:2006-01-02" (or "since:2006-01-02") to count from a date
+func ParseWindow(s string) (Window, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "month" || s == "year":
+		return Window{Name: s, Calendar: s}, nil
+
+	case strings.HasSuffix(s, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return Window{}, fmt.Errorf("bad number of days in window %q", s)
+		}
+		return Window{Name: s, Days: days}, nil
+
+	case strings.Contains(s, ":"):
+		parts := strings.SplitN(s, ":", 2)
+		since, err := time.ParseInLocation("2006-01-02", parts[1], time.Local)
+		if err != nil {
+			return Window{}, fmt.Errorf("bad date in window %q: %v", s, err)
+		}
+		return Window{Name: parts[0], Since: since}, nil
+	}
+
+	return Window{}, fmt.Errorf("unknown window %q", s)
+}
+
+// ParseWindows parses a comma-separated list of windows, as for ParseWindow
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, w := range strings.Split(s, ",") {
+		if strings.TrimSpace(w) == "" {
+			continue
+		}
+		window, err := ParseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// Contains says whether an event at t falls in the window, for a report at now
+func (w Window) Contains(t time.Time, now time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+
+	switch {
+	case w.Calendar == "month":
+		return !t.Before(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	case w.Calendar == "year":
+		return !t.Before(time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()))
+	case !w.Since.IsZero():
+		return !t.Before(w.Since)
+	}
+
+	daysAgo := int(now.Sub(t).Hours() / 24)
+	return daysAgo <= w.Days
+}
+
+// Aggregate works out stats over each of the windows from a rider's events, for a report at now
+func Aggregate(events []Event, windows []Window, now time.Time) []WindowStats {
+	stats := make([]WindowStats, len(windows))
+	for i, w := range windows {
+		stats[i].Window = w
+		for _, e := range events {
+			if !w.Contains(e.EventDate, now) {
+				continue
+			}
+
+			stats[i].Rides++
+			if e.IsRace() {
+				stats[i].Races++
+			}
+			if e.WkgFtp.Value > stats[i].Ftp {
+				stats[i].Ftp = e.WkgFtp.Value
+			}
+		}
+	}
+	return stats
+}
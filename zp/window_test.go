@@ -0,0 +1,102 @@
+package zp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("7d, 28d,month,year,season:2026-09-01")
+	if err != nil {
+		t.Fatalf("ParseWindows: %v", err)
+	}
+
+	expected := []Window{
+		{Name: "7d", Days: 7},
+		{Name: "28d", Days: 28},
+		{Name: "month", Calendar: "month"},
+		{Name: "year", Calendar: "year"},
+		{Name: "season", Since: time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local)},
+	}
+	if len(windows) != len(expected) {
+		t.Fatalf("Got %d windows, expected %d", len(windows), len(expected))
+	}
+	for i := range expected {
+		if windows[i].Name != expected[i].Name || windows[i].Days != expected[i].Days ||
+			windows[i].Calendar != expected[i].Calendar || !windows[i].Since.Equal(expected[i].Since) {
+			t.Errorf("Window %d: got %+v expected %+v", i, windows[i], expected[i])
+		}
+	}
+
+	for _, bad := range []string{"xd", "-3d", "week", "season:soon"} {
+		if _, err := ParseWindow(bad); err == nil {
+			t.Errorf("Expected an error parsing %q", bad)
+		}
+	}
+
+	windows, err = ParseWindows("")
+	if err != nil || len(windows) != 0 {
+		t.Errorf("Got %v, %v for no windows", windows, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	day := 24 * time.Hour
+	events := []Event{
+		{EventType: "TYPE_RACE", EventDate: now.Add(-1 * day), WkgFtp: Tuple{Value: 3.1, Valid: true}},
+		{EventType: "TYPE_RIDE", EventDate: now.Add(-3 * day), WkgFtp: Tuple{Value: 3.4, Valid: true}},
+		{EventType: "TYPE_RACE", EventDate: now.Add(-20 * day), WkgFtp: Tuple{Value: 3.6, Valid: true}},
+		{EventType: "TYPE_RACE", EventDate: now.Add(-70 * day), WkgFtp: Tuple{Value: 3.8, Valid: true}},
+		{EventType: "TYPE_RIDE", WkgFtp: Tuple{Value: 9.9, Valid: true}},
+	}
+
+	windows, err := ParseWindows("2d,7d,month,year,season:2026-02-01")
+	if err != nil {
+		t.Fatalf("ParseWindows: %v", err)
+	}
+
+	expected := []WindowStats{
+		{Rides: 1, Races: 1, Ftp: 3.1},
+		{Rides: 2, Races: 1, Ftp: 3.4},
+		{Rides: 1, Races: 1, Ftp: 3.1},
+		{Rides: 3, Races: 2, Ftp: 3.6},
+		{Rides: 3, Races: 2, Ftp: 3.6},
+	}
+
+	stats := Aggregate(events, windows, now)
+	for i, e := range expected {
+		s := stats[i]
+		if s.Window.Name != windows[i].Name || s.Rides != e.Rides || s.Races != e.Races || s.Ftp != e.Ftp {
+			t.Errorf("Window %s: got %+v expected %+v", windows[i].Name, s, e)
+		}
+	}
+}
+
+func TestStringsWithWindows(t *testing.T) {
+	windows := []Window{{Name: "7d", Days: 7}, {Name: "month", Calendar: "month"}}
+	r := Rider{
+		Name: "Liz Rice",
+		Zwid: 98588,
+		Windows: []WindowStats{
+			{Window: windows[0], Races: 2, Ftp: 3.14},
+			{Window: windows[1], Races: 5, Ftp: 3.25},
+		},
+	}
+
+	ss := r.Strings()
+	header := DefaultSchema(windows, English).Header()
+	if len(ss) != 18 || len(header) != len(ss) {
+		t.Fatalf("Got %d columns and %d headers, expected 18", len(ss), len(header))
+	}
+
+	expected := []string{"3.1", "2", "3.2", "5"}
+	for i, e := range expected {
+		if ss[14+i] != e {
+			t.Errorf("Column %s: got %s expected %s", header[14+i], ss[14+i], e)
+		}
+	}
+	if header[16] != "FTP month" {
+		t.Errorf("Got header %s", header[16])
+	}
+}
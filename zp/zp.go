@@ -1,19 +1,21 @@
 package zp
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type club struct {
-	Data []Rider
+	Data []json.RawMessage
 }
 
 // Rider shows data about a rider
@@ -33,20 +35,96 @@ type Rider struct {
 	LatestEvent      string
 	LatestRaceAvgWkg float64
 	LatestRaceWkgFtp float64
+	// AsOf is the time the stats were worked out for
+	AsOf time.Time
+	// Windows has stats for the Client's extra aggregation windows
+	Windows []WindowStats
+	// Warnings describe events that couldn't be parsed, and so were left out
+	Warnings []string
+	// Category is the rider's estimated pace category, from recent race results
+	Category CategoryEstimate
+	// Fingerprint identifies the rider's entry in the club list, so we can tell if it's changed since the last import
+	Fingerprint string
 }
 
 type riderData struct {
-	Data []Event
+	Data []json.RawMessage
 }
 
-// Event is a ZwiftPower event
+// Event is a ZwiftPower event. Most measurements come as Tuples, where the flag
+// marks the rider's best value.
 type Event struct {
 	EventType     string        `json:"f_t"`
 	EventDateSecs EventDateType `json:"event_date"`
 	EventDate     time.Time
-	EventTitle    string      `json:"event_title"`
-	AvgWkg        interface{} `json:"avg_wkg"`
-	WkgFtp        interface{} `json:"wkg_ftp"`
+	EventTitle    string `json:"event_title"`
+	// EventID is ZwiftPower's ID for the event, as in events.php?zid=
+	EventID       ID     `json:"zid"`
+	Category      string `json:"category"`
+	Position      int    `json:"pos"`
+	PositionInCat int    `json:"position_in_cat"`
+	// Time is how long the event took, in seconds
+	Time Tuple `json:"time"`
+	// Distance is in km
+	Distance Tuple `json:"distance"`
+	AvgWkg   Tuple `json:"avg_wkg"`
+	WkgFtp   Tuple `json:"wkg_ftp"`
+	// WattsFtp is the FTP from this event in watts, to go with WkgFtp
+	WattsFtp Tuple `json:"wftp"`
+	AvgPower Tuple `json:"avg_power"`
+	// NormalizedPower is in watts
+	NormalizedPower Tuple `json:"np"`
+	AvgHR           Tuple `json:"avg_hr"`
+	MaxHR           Tuple `json:"max_hr"`
+	// Weight is in kg
+	Weight Tuple `json:"weight"`
+	// Height is in cm
+	Height Tuple `json:"height"`
+
+	// Critical power, in watts and W/kg, for 5s, 1, 5 and 20 minutes.
+	// ZwiftPower doesn't send a maximum power, so 5s power is the closest thing to it.
+	Watts5    Tuple `json:"w5"`
+	Watts60   Tuple `json:"w60"`
+	Watts300  Tuple `json:"w300"`
+	Watts1200 Tuple `json:"w1200"`
+	Wkg5      Tuple `json:"wkg5"`
+	Wkg60     Tuple `json:"wkg60"`
+	Wkg300    Tuple `json:"wkg300"`
+	Wkg1200   Tuple `json:"wkg1200"`
+
+	// Male is 1 for results in the men's (open) field, and 0 for women's
+	Male Tuple `json:"male"`
+	// Upgrade is 1 if ZwiftPower flagged the result as one that gets the rider upgraded
+	Upgrade Tuple `json:"upg"`
+}
+
+// Duration is how long the event took
+func (e Event) Duration() time.Duration {
+	return time.Duration(e.Time.Value * float64(time.Second))
+}
+
+// IsRace says whether this event was a race, rather than a group ride or workout
+func (e Event) IsRace() bool {
+	return strings.Contains(e.EventType, "RACE")
+}
+
+// ID is an identifier that ZwiftPower sometimes sends as a string and sometimes as a number
+type ID string
+
+// UnmarshalJSON accepts a string or a number
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = ID(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("parsing ID %s: %v", data, err)
+	}
+	*id = ID(n.String())
+	return nil
 }
 
 // EventDateType so we can use a custom unmarshaller
@@ -63,25 +141,200 @@ func (e *EventDateType) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func NewClient() (*http.Client, error) {
+// DefaultBaseURL is where the ZwiftPower site lives
+const DefaultBaseURL = "https://www.zwiftpower.com"
+
+// Client fetches data from ZwiftPower, or from anything that looks like it
+type Client struct {
+	// BaseURL is prefixed to every request path, e.g. to point at a local fixture server
+	BaseURL string
+	// UserAgent is sent on every request if it's set
+	UserAgent string
+	// Transport is used for requests. If nil, http.DefaultTransport is used
+	Transport http.RoundTripper
+	// RequestsPerSecond limits how fast we make requests, across all goroutines using this client. Zero means no limit
+	RequestsPerSecond float64
+	// Retries is how many times a request is retried after a transient error, such as a timeout or a 5xx status
+	Retries int
+	// Backoff is how long to wait before the first retry. It doubles for each retry after that
+	Backoff time.Duration
+	// Windows are extra periods to work out riders' stats over, on top of the fixed 30, 60, 90 and 365 days
+	Windows []Window
+	// AsOf is the time that riders' stats are worked out for, so that old reports can be regenerated.
+	// Zero means now.
+	AsOf time.Time
+	// Cache, if set, keeps responses for a while so they don't have to be fetched again
+	Cache *Cache
+
+	jar     http.CookieJar
+	limiter limiter
+}
+
+// Tuple is one of ZwiftPower's "[value, flag]" pairs, such as ["2.7", 0] or [152, 1].
+// The value can be a number or a string, and can be missing altogether.
+type Tuple struct {
+	Value float64
+	Flag  int
+	// Valid is false if ZwiftPower didn't supply a value
+	Valid bool
+}
+
+// UnmarshalJSON accepts a [value, flag] array, or a bare value. Values can be numbers,
+// numeric strings, empty strings or null; anything else is an error.
+func (t *Tuple) UnmarshalJSON(data []byte) error {
+	*t = Tuple{}
+
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		// Not an array, so treat it as the value on its own
+		pair = []json.RawMessage{data}
+	}
+
+	if len(pair) == 0 {
+		return nil
+	}
+
+	v, ok, err := parseNumber(pair[0])
+	if err != nil {
+		return fmt.Errorf("parsing value %s: %v", pair[0], err)
+	}
+	t.Value, t.Valid = v, ok
+
+	if len(pair) > 1 {
+		flag, _, err := parseNumber(pair[1])
+		if err != nil {
+			return fmt.Errorf("parsing flag %s: %v", pair[1], err)
+		}
+		t.Flag = int(flag)
+	}
+
+	return nil
+}
+
+// parseNumber handles a JSON number, a string holding a number, an empty string or null.
+// ok is false if there's no value.
+func parseNumber(data json.RawMessage) (v float64, ok bool, err error) {
+	var i interface{}
+	if err := json.Unmarshal(data, &i); err != nil {
+		return 0, false, err
+	}
+
+	switch x := i.(type) {
+	case nil:
+		return 0, false, nil
+	case float64:
+		return x, true, nil
+	case string:
+		x = strings.TrimSpace(x)
+		if x == "" {
+			return 0, false, nil
+		}
+		v, err := strconv.ParseFloat(x, 64)
+		return v, err == nil, err
+	default:
+		return 0, false, fmt.Errorf("unexpected type %T", i)
+	}
+}
+
+// NewClient gets a Client for the real ZwiftPower site
+func NewClient() (*Client, error) {
 	log.Printf("NewClient")
-	jar, err := cookiejar.New(nil)
+	jar, err := newSessionJar()
 	if err != nil {
 		return nil, err
 	}
 
-	client := &http.Client{
-		Jar: jar,
+	client := &Client{
+		BaseURL: DefaultBaseURL,
+		Retries: 3,
+		Backoff: time.Second,
+		jar:     jar,
 	}
 
 	return client, nil
 }
 
+// now is the time we're working out stats for
+func (c *Client) now() time.Time {
+	if c.AsOf.IsZero() {
+		return time.Now()
+	}
+	return c.AsOf
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+// get makes a rate-limited GET request. It backs off and retries if ZwiftPower says we're making too many
+// requests, or if the request fails in a way that might work next time.
+func (c *Client) get(url string) (*http.Response, error) {
+	return c.getWithHeader(url, nil)
+}
+
+// getWithHeader is get with extra request headers, such as for a conditional request
+func (c *Client) getWithHeader(url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	hc := &http.Client{
+		Jar:       c.jar,
+		Transport: c.Transport,
+	}
+
+	var throttled, retries int
+	for {
+		c.limiter.wait(c.RequestsPerSecond)
+		resp, err := hc.Do(req)
+
+		switch {
+		case err != nil:
+			if !isTransient(err) || retries >= c.Retries {
+				return nil, err
+			}
+			log.Printf("Retrying %s after error: %v", url, err)
+
+		case resp.StatusCode == http.StatusTooManyRequests:
+			if throttled >= maxThrottleRetries {
+				return resp, nil
+			}
+			throttled++
+			wait := retryAfter(resp)
+			resp.Body.Close()
+			log.Printf("Too many requests for %s, waiting %v", url, wait)
+			c.limiter.pause(time.Now().Add(wait))
+			continue
+
+		case resp.StatusCode >= 500:
+			if retries >= c.Retries {
+				return resp, nil
+			}
+			resp.Body.Close()
+			log.Printf("Retrying %s after status %d", url, resp.StatusCode)
+
+		default:
+			return resp, nil
+		}
+
+		time.Sleep(c.Backoff << retries)
+		retries++
+	}
+}
+
 // ImportZP imports data about the club with this ID
-func ImportZP(client *http.Client, clubID int) ([]Rider, error) {
-	data, err := getJSON(client, fmt.Sprintf("https://www.zwiftpower.com/cache3/teams/%d_riders.json", clubID))
+func ImportZP(client *Client, clubID int) ([]Rider, error) {
+	data, err := getJSON(client, client.url(fmt.Sprintf("/cache3/teams/%d_riders.json", clubID)))
 	if err != nil {
-		return nil, fmt.Errorf("getting club data: %v", err)
+		return nil, fmt.Errorf("getting club data: %w", err)
 	}
 
 	var c club
@@ -90,114 +343,188 @@ func ImportZP(client *http.Client, clubID int) ([]Rider, error) {
 		return nil, fmt.Errorf("unmarshalling club data: %v", err)
 	}
 
-	return c.Data, nil
+	riders := make([]Rider, len(c.Data))
+	for i, raw := range c.Data {
+		if err := json.Unmarshal(raw, &riders[i]); err != nil {
+			return nil, fmt.Errorf("unmarshalling club data: %v", err)
+		}
+		riders[i].Fingerprint = fingerprint(raw)
+	}
+	return riders, nil
+}
+
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// RiderProfile is a rider's summary along with the events it was worked out from
+type RiderProfile struct {
+	Rider  Rider
+	Events []Event
 }
 
 // ImportRider imports data about the rider with this ID
-func ImportRider(client *http.Client, riderID int) (rider Rider, err error) {
-	// I think hitting the profile URL loads the data into the cache
+func ImportRider(client *Client, riderID int) (rider Rider, err error) {
+	profile, err := ImportRiderEvents(client, riderID)
+	return profile.Rider, err
+}
+
+// ImportRiderEvents imports data about the rider with this ID, keeping all their events.
+// The events are sorted oldest first, and have EventDate filled in (it's zero if ZwiftPower doesn't give a date).
+func ImportRiderEvents(client *Client, riderID int) (profile RiderProfile, err error) {
 	log.Printf("ImportRider(%d)", riderID)
-	_, _ = client.Get(fmt.Sprintf("https://www.zwiftpower.com/profile.php?z=%d", riderID))
-	data, err := getJSON(client, fmt.Sprintf("https://www.zwiftpower.com/cache3/profile/%d_all.json", riderID))
+	profile.Rider.Zwid = riderID
+	url := client.url(fmt.Sprintf("/cache3/profile/%d_all.json", riderID))
+	data, ok := client.fromCache(url)
+	if ok {
+		return profileFromJSON(client, riderID, data)
+	}
+
+	// I think hitting the profile URL loads the data into the cache
+	resp, err := client.get(client.url(fmt.Sprintf("/profile.php?z=%d", riderID)))
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	data, err = fetchJSON(client, url)
 	if err != nil {
-		return rider, err
+		return profile, err
 	}
 
-	var r riderData
-	err = json.Unmarshal(data, &r)
+	// Only cache data we could parse, so a bad response gets fetched again next time
+	profile, err = profileFromJSON(client, riderID, data)
+	if err == nil {
+		client.toCache(url, data)
+	}
+	return profile, err
+}
+
+// profileFromJSON works out the rider's profile from the body of their _all.json
+func profileFromJSON(client *Client, riderID int, data []byte) (profile RiderProfile, err error) {
+	profile.Rider.Zwid = riderID
+	events, warnings, err := parseEvents(data)
 	if err != nil {
 		log.Printf("Error unmarshalling data: %v", err)
 		log.Printf(string(data))
-		return rider, err
+		return profile, err
 	}
 
-	rider.Zwid = riderID
-	if len(r.Data) < 1 {
+	if len(events) < 1 && len(warnings) < 1 {
 		log.Printf("No event data for rider %d", riderID)
-		return rider, nil
+	}
+	for _, w := range warnings {
+		log.Printf("Skipping %s for rider %d", w, riderID)
 	}
 
-	var latestEventDate time.Time
-	var latestRaceDate time.Time
-	for _, e := range r.Data {
-		e.EventDate = time.Unix(int64(e.EventDateSecs), 0)
-		daysAgo := int(time.Now().Sub(e.EventDate).Hours() / 24)
-		// log.Printf("date %v, from %v is %d days ago\n", e.EventDate, e.EventDateSecs, daysAgo)
-		isRace := strings.Contains(e.EventType, "RACE")
-
-		if daysAgo <= 365 {
-			rider.Rides++
-			if isRace {
-				rider.Races++
-			}
-		}
+	profile.Events = events
+	profile.Rider = Summarise(riderID, events, client.Windows, client.now())
+	profile.Rider.Warnings = warnings
+	return profile, nil
+}
 
-		var wkgFtp float64
-		var avgWkg float64
+// parseEvents unmarshals a rider's event list. Events that can't be parsed are left out, and described in warnings.
+func parseEvents(data []byte) (events []Event, warnings []string, err error) {
+	var r riderData
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		eventWkgFtp := e.WkgFtp.([]interface{})
-		wkgFtp, ok := eventWkgFtp[0].(float64)
-		if !ok {
-			wkgFtp, err = strconv.ParseFloat(eventWkgFtp[0].(string), 64)
-			if err != nil {
-				log.Fatal(err)
-			}
+	events = make([]Event, 0, len(r.Data))
+	for i, raw := range r.Data {
+		var e Event
+		if err := json.Unmarshal(raw, &e); err != nil {
+			warnings = append(warnings, fmt.Sprintf("event %d (%s): %v", i, eventTitle(raw), err))
+			continue
 		}
 
-		avgWkg, err = strconv.ParseFloat(e.AvgWkg.([]interface{})[0].(string), 64)
-		if err != nil {
-			log.Fatal(err)
+		if e.EventDateSecs != 0 {
+			e.EventDate = time.Unix(int64(e.EventDateSecs), 0)
 		}
+		events = append(events, e)
+	}
 
-		// Last three months?
-		if daysAgo <= 90 {
-			if wkgFtp > rider.Ftp90 {
-				rider.Ftp90 = wkgFtp
-			}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].EventDate.Before(events[j].EventDate)
+	})
+	return events, warnings, nil
+}
 
-			if isRace {
-				rider.Races90++
-			}
-		}
+// Summarise works out the rider's stats from their events as of a particular time, including stats over any extra windows.
+// Events after asOf are ignored.
+func Summarise(riderID int, events []Event, windows []Window, asOf time.Time) (rider Rider) {
+	rider.Zwid = riderID
+	rider.AsOf = asOf
 
-		// Last two months?
-		if daysAgo <= 60 {
-			if wkgFtp > rider.Ftp60 {
-				rider.Ftp60 = wkgFtp
-			}
+	var past []Event
+	for _, e := range events {
+		if !e.EventDate.After(asOf) {
+			past = append(past, e)
 		}
+	}
+	events = past
 
-		// Last month?
-		if daysAgo <= 30 {
-			if isRace {
-				rider.Races30++
-			}
+	stats := Aggregate(events, DefaultWindows, asOf)
+	rider.Ftp30, rider.Races30 = stats[0].Ftp, stats[0].Races
+	rider.Ftp60 = stats[1].Ftp
+	rider.Ftp90, rider.Races90 = stats[2].Ftp, stats[2].Races
+	rider.Rides, rider.Races = stats[3].Rides, stats[3].Races
 
-			if wkgFtp > rider.Ftp30 {
-				rider.Ftp30 = wkgFtp
-			}
-		}
+	if len(windows) > 0 {
+		rider.Windows = Aggregate(events, windows, asOf)
+	}
 
+	var latestEventDate time.Time
+	var latestRaceDate time.Time
+	for _, e := range events {
 		if e.EventDate.After(latestEventDate) {
 			latestEventDate = e.EventDate
 			rider.LatestEvent = e.EventTitle
 		}
 
-		if isRace && e.EventDate.After(latestRaceDate) {
+		if e.IsRace() && e.EventDate.After(latestRaceDate) {
 			latestRaceDate = e.EventDate
 			rider.LatestRace = e.EventTitle
-			rider.LatestRaceAvgWkg = avgWkg
-			rider.LatestRaceWkgFtp = wkgFtp
+			rider.LatestRaceAvgWkg = e.AvgWkg.Value
+			rider.LatestRaceWkgFtp = e.WkgFtp.Value
 		}
 	}
 
 	rider.LatestEventDate = latestEventDate
 	rider.LatestRaceDate = latestRaceDate
-	return rider, nil
+	rider.Category = EstimateCategory(events, asOf, DefaultCategoryRules)
+	return rider
+}
+
+// eventTitle does its best to find the title of an event that we couldn't unmarshal
+func eventTitle(raw json.RawMessage) string {
+	var e struct {
+		EventTitle string `json:"event_title"`
+	}
+	json.Unmarshal(raw, &e)
+	if e.EventTitle == "" {
+		return "untitled"
+	}
+	return e.EventTitle
 }
 
-func getJSON(client *http.Client, url string) ([]byte, error) {
-	resp, err := client.Get(url)
+// getJSON gets the JSON at url, from the Client's cache if it's there
+func getJSON(client *Client, url string) ([]byte, error) {
+	if data, ok := client.fromCache(url); ok {
+		return data, nil
+	}
+
+	data, err := fetchJSON(client, url)
+	if err == nil {
+		client.toCache(url, data)
+	}
+	return data, err
+}
+
+// fetchJSON gets the JSON at url from ZwiftPower
+func fetchJSON(client *Client, url string) ([]byte, error) {
+	resp, err := client.get(url)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -208,50 +535,42 @@ func getJSON(client *http.Client, url string) ([]byte, error) {
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && isLoginPage(body) {
+		return []byte{}, fmt.Errorf("getting %s: %w", url, ErrNotLoggedIn)
+	}
 	return body, err
 }
 
-// MonthsAgo describes how many months since the rider's latest event
-func (r Rider) MonthsAgo() string {
-	if r.LatestEventDate.IsZero() {
-		return "No latest event"
+// Recency says how long before the time of the rider's stats (or now, if that isn't set) their latest event was
+func (r Rider) Recency() Recency {
+	asOf := r.AsOf
+	if asOf.IsZero() {
+		asOf = time.Now()
 	}
 
-	if time.Now().Sub(r.LatestEventDate) > (time.Hour * 24 * 365) {
-		return "Over a year ago"
-	}
+	return RecencyOf(r.LatestEventDate, asOf)
+}
 
-	monthDiff := time.Now().Month() - r.LatestEventDate.Month()
-	if monthDiff < 0 {
-		monthDiff += 12
-	}
+// MonthsAgo describes how many months since the rider's latest event
+func (r Rider) MonthsAgo() string {
+	return r.Recency().Format(English)
+}
 
-	switch monthDiff {
-	case 0:
-		return "This month"
-	case 1:
-		return "Last month"
-	default:
-		return fmt.Sprintf("%d months ago", monthDiff)
+// Strings turns a rider struct into []string, with the default columns
+func (r Rider) Strings() []string {
+	return r.StringsIn(English)
+}
+
+// StringsIn turns a rider struct into []string, with the default columns and words in the given language
+func (r Rider) StringsIn(lang Language) []string {
+	windows := make([]Window, len(r.Windows))
+	for i, w := range r.Windows {
+		windows[i] = w.Window
 	}
+	return DefaultSchema(windows, lang).Row(r)
 }
 
-// Strings turns a rider struct into []string
-func (r Rider) Strings() []string {
-	output := make([]string, 14)
-	output[0] = r.Name
-	output[1] = strconv.Itoa(r.Zwid)
-	output[2] = r.LatestEventDate.Format("2006-01-02")
-	output[3] = r.MonthsAgo()
-	output[4] = r.LatestEvent
-	output[5] = strconv.Itoa(r.Rides)
-	output[6] = fmt.Sprintf("https://www.zwiftpower.com/profile.php?z=%d", r.Zwid)
-	output[7] = strconv.FormatFloat(r.Ftp30, 'f', 1, 64)
-	output[8] = strconv.FormatFloat(r.Ftp90, 'f', 1, 64)
-	output[9] = strconv.Itoa(r.Races30)
-	output[10] = strconv.Itoa(r.Races90)
-	output[11] = strconv.Itoa(r.Races)
-	output[12] = r.LatestRace
-	output[13] = r.LatestRaceDate.Format("2006-01-02")
-	return output
+// ProfileURL is the rider's profile page on ZwiftPower
+func ProfileURL(zwid int) string {
+	return fmt.Sprintf("%s/profile.php?z=%d", DefaultBaseURL, zwid)
 }
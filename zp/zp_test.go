@@ -2,28 +2,37 @@ package zp
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
 )
 
 func TestRiderMonthsAgoString(t *testing.T) {
+	asOf := time.Date(2021, 5, 20, 10, 0, 0, 0, time.UTC)
 	cases := []struct {
 		d        time.Time
 		expected string
 	}{
-		{d: time.Now(), expected: "This month"},
-		{d: time.Now().Add(-1 * 30 * 24 * time.Hour), expected: "Last month"},
-		{d: time.Now().Add(-2 * 30 * 24 * time.Hour), expected: "2 months ago"},
-		{d: time.Now().Add(-3 * 30 * 24 * time.Hour), expected: "3 months ago"},
-		{d: time.Now().Add(-13 * 30 * 24 * time.Hour), expected: "Over a year ago"},
+		{d: asOf, expected: "This month"},
+		{d: asOf.Add(-1 * 30 * 24 * time.Hour), expected: "Last month"},
+		{d: asOf.Add(-2 * 30 * 24 * time.Hour), expected: "2 months ago"},
+		{d: asOf.Add(-3 * 30 * 24 * time.Hour), expected: "3 months ago"},
+		{d: asOf.Add(-13 * 30 * 24 * time.Hour), expected: "Over a year ago"},
+		{d: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC), expected: "This month"},
+		{d: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), expected: "5 months ago"},
 		{expected: "No latest event"},
 	}
 
 	for i, c := range cases {
 		r := Rider{
 			LatestEventDate: c.d,
+			AsOf:            asOf,
 		}
 		result := r.MonthsAgo()
 		if result != c.expected {
@@ -32,6 +41,42 @@ func TestRiderMonthsAgoString(t *testing.T) {
 	}
 }
 
+func TestImportAsOf(t *testing.T) {
+	srv := zptest.NewServer("testdata")
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.AsOf = time.Date(2021, 2, 10, 0, 0, 0, 0, time.UTC)
+
+	rider, err := ImportRider(client, 1261784)
+	if err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+
+	expected := Rider{Ftp30: 2.9, Ftp90: 2.9, Races30: 2, Races90: 7, Rides: 13, Races: 13}
+	if rider.Ftp30 != expected.Ftp30 || rider.Ftp90 != expected.Ftp90 || rider.Races30 != expected.Races30 ||
+		rider.Races90 != expected.Races90 || rider.Rides != expected.Rides || rider.Races != expected.Races {
+		t.Errorf("Got %+v", rider)
+	}
+	if rider.MonthsAgo() != "This month" {
+		t.Errorf("Got %s", rider.MonthsAgo())
+	}
+
+	// Going back further leaves out the later events
+	client.AsOf = time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	rider, err = ImportRider(client, 1261784)
+	if err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+	if rider.Rides != 6 || rider.LatestEventDate.Unix() != 1603849500 {
+		t.Errorf("Got %d rides, latest %v", rider.Rides, rider.LatestEventDate)
+	}
+}
+
 func TestRiderStrings(t *testing.T) {
 	ss := strings.Split("Liz Rice,98588,2020-04-15,This month,ZZRC SUB 2.0 Ride,160,https://www.zwiftpower.com/profile.php?z=98588,2.5,2.7,0,3,47,Stage 4 Race - Tour of Watopia 2020,2020-03-21", ",")
 
@@ -64,10 +109,22 @@ func TestRiderStrings(t *testing.T) {
 func TestFormatAsExpected(t *testing.T) {
 	ss := strings.Split("Some name,98588,2020-04-15,This month,ZZRC SUB 2.0 Ride,160,https://www.zwiftpower.com/profile.php?z=98588,2.5,2.7,0,3,47,Stage 4 Race - Tour of Watopia 2020,2020-03-21", ",")
 
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/profile.php":
+		case "/cache3/profile/98588_all.json":
+			fmt.Fprintf(w, recentEvents, time.Now().Add(-24*time.Hour).Unix(), time.Now().Add(-48*time.Hour).Unix())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
 	client, err := NewClient()
 	if err != nil {
 		t.Fatalf("Failed to log in: %v", err)
 	}
+	client.BaseURL = srv.URL
 
 	rider, err := ImportRider(client, 98588)
 	if err != nil {
@@ -130,6 +187,172 @@ func TestFormatAsExpected(t *testing.T) {
 	}
 }
 
+func TestClientSettings(t *testing.T) {
+	var gotPath, gotAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"data":[{"name":"Some name","zwid":98588}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL + "/"
+	client.UserAgent = "zp-test"
+	client.Transport = srv.Client().Transport
+
+	riders, err := ImportZP(client, 2740)
+	if err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if len(riders) != 1 || riders[0].Zwid != 98588 || riders[0].Name != "Some name" {
+		t.Errorf("Unexpected riders %v", riders)
+	}
+	if gotPath != "/cache3/teams/2740_riders.json" {
+		t.Errorf("Got path %s", gotPath)
+	}
+	if gotAgent != "zp-test" {
+		t.Errorf("Got user agent %s", gotAgent)
+	}
+}
+
+func TestImportFromFixtures(t *testing.T) {
+	srv := zptest.NewServer("testdata")
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	riders, err := ImportZP(client, 2672)
+	if err != nil {
+		t.Fatalf("ImportZP: %v", err)
+	}
+	if len(riders) != 2 {
+		t.Fatalf("Got %d riders, expected 2", len(riders))
+	}
+
+	rider, err := ImportRider(client, riders[0].Zwid)
+	if err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+	// The social ride has no date, so it's never the latest
+	if rider.LatestEvent != "Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1" {
+		t.Errorf("Got latest event %s", rider.LatestEvent)
+	}
+	if rider.LatestRace != "Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1" {
+		t.Errorf("Got latest race %s", rider.LatestRace)
+	}
+	if rider.LatestRaceDate.Unix() != 1612320300 {
+		t.Errorf("Got latest race date %v", rider.LatestRaceDate)
+	}
+	if rider.LatestRaceAvgWkg != 3.0 || rider.LatestRaceWkgFtp != 2.9 {
+		t.Errorf("Got latest race avg %v, ftp %v", rider.LatestRaceAvgWkg, rider.LatestRaceWkgFtp)
+	}
+
+	_, err = ImportRider(client, 1)
+	if err == nil {
+		t.Errorf("Expected an error for a missing rider")
+	}
+}
+
+func TestImportRiderEvents(t *testing.T) {
+	srv := zptest.NewServer("testdata")
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	profile, err := ImportRiderEvents(client, 1261784)
+	if err != nil {
+		t.Fatalf("ImportRiderEvents: %v", err)
+	}
+	if profile.Rider.Zwid != 1261784 || profile.Rider.LatestRaceDate.Unix() != 1612320300 {
+		t.Errorf("Unexpected rider %+v", profile.Rider)
+	}
+	if len(profile.Events) != 14 {
+		t.Fatalf("Got %d events, expected 14", len(profile.Events))
+	}
+
+	// The undated social ride sorts first, then the rest are in date order
+	if !profile.Events[0].EventDate.IsZero() || profile.Events[0].EventTitle != "REVO Social SUB2" {
+		t.Errorf("Unexpected first event %+v", profile.Events[0])
+	}
+	for i := 2; i < len(profile.Events); i++ {
+		if profile.Events[i].EventDate.Before(profile.Events[i-1].EventDate) {
+			t.Errorf("Event %d is out of order", i)
+		}
+	}
+	if profile.Events[1].EventDate.Unix() != 1601736300 {
+		t.Errorf("Unexpected date for event 1: %v", profile.Events[1].EventDate)
+	}
+}
+
+func TestUnmarshalTuple(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected Tuple
+		err      bool
+	}{
+		{data: `["2.7",0]`, expected: Tuple{Value: 2.7, Valid: true}},
+		{data: `[2.5,1]`, expected: Tuple{Value: 2.5, Flag: 1, Valid: true}},
+		{data: `["56.3","1"]`, expected: Tuple{Value: 56.3, Flag: 1, Valid: true}},
+		{data: `["",0]`, expected: Tuple{}},
+		{data: `[null,0]`, expected: Tuple{}},
+		{data: `[]`, expected: Tuple{}},
+		{data: `null`, expected: Tuple{}},
+		{data: `""`, expected: Tuple{}},
+		{data: `"3.1"`, expected: Tuple{Value: 3.1, Valid: true}},
+		{data: `["abc",0]`, err: true},
+		{data: `[{},0]`, err: true},
+	}
+
+	for i, c := range cases {
+		var v Tuple
+		err := json.Unmarshal([]byte(c.data), &v)
+		if (err != nil) != c.err {
+			t.Errorf("Case %d: unexpected error %v", i, err)
+			continue
+		}
+		if !c.err && v != c.expected {
+			t.Errorf("Case %d: got %+v expected %+v", i, v, c.expected)
+		}
+	}
+}
+
+func TestImportRiderWarnings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"event_title":"Bad","f_t":"TYPE_RACE","event_date":1601736300,"avg_wkg":["oops",0],"wkg_ftp":["2.5",0]},` +
+			`{"event_title":"Good","f_t":"TYPE_RACE","event_date":1601994600,"avg_wkg":["",0],"wkg_ftp":[2.6,0]}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Failed to get client: %v", err)
+	}
+	client.BaseURL = srv.URL
+
+	rider, err := ImportRider(client, 98588)
+	if err != nil {
+		t.Fatalf("ImportRider: %v", err)
+	}
+	if len(rider.Warnings) != 1 || !strings.Contains(rider.Warnings[0], "Bad") {
+		t.Errorf("Unexpected warnings %v", rider.Warnings)
+	}
+	if rider.LatestRace != "Good" || rider.LatestRaceWkgFtp != 2.6 || rider.LatestRaceAvgWkg != 0 {
+		t.Errorf("Unexpected latest race %s, %v, %v", rider.LatestRace, rider.LatestRaceWkgFtp, rider.LatestRaceAvgWkg)
+	}
+}
+
 func TestUnmarshalEvent(t *testing.T) {
 	var r riderData
 	err := json.Unmarshal([]byte(testdata), &r)
@@ -142,8 +365,49 @@ func TestUnmarshalEvent(t *testing.T) {
 	if err != nil {
 		t.Errorf("Failed unmarshalling event: %v", err)
 	}
+
+	if e.EventID != "1118313" || e.Category != "N/A" || e.Position != 5 || e.PositionInCat != 0 {
+		t.Errorf("Unexpected event details %+v", e)
+	}
+	if e.Duration() != time.Hour || e.Distance.Value != 0 || e.IsRace() {
+		t.Errorf("Unexpected duration %v, distance %v or race %v", e.Duration(), e.Distance, e.IsRace())
+	}
+
+	expected := []struct {
+		name  string
+		got   Tuple
+		value float64
+	}{
+		{"avg power", e.AvgPower, 102},
+		{"normalized power", e.NormalizedPower, 107},
+		{"avg HR", e.AvgHR, 134},
+		{"max HR", e.MaxHR, 152},
+		{"weight", e.Weight, 56.3},
+		{"height", e.Height, 165},
+		{"5s watts", e.Watts5, 150},
+		{"1m watts", e.Watts60, 141},
+		{"5m watts", e.Watts300, 114},
+		{"20m watts", e.Watts1200, 106},
+		{"5s wkg", e.Wkg5, 2.7},
+		{"1m wkg", e.Wkg60, 2.5},
+		{"5m wkg", e.Wkg300, 2.0},
+		{"20m wkg", e.Wkg1200, 1.9},
+	}
+	for _, x := range expected {
+		if !x.got.Valid || x.got.Value != x.value {
+			t.Errorf("Got %s %+v, expected %v", x.name, x.got, x.value)
+		}
+	}
+
+	var id ID
+	if err := json.Unmarshal([]byte(`1234`), &id); err != nil || id != "1234" {
+		t.Errorf("Got ID %s, %v from a number", id, err)
+	}
 }
 
 const testdata = `{"data":[{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"4","zid":"1096124","pos":107,"position_in_cat":2,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1096124.107","lag":0,"uid":"3153245763137311192","time":[1557.351,1],"time_gun":1557.531,"gap":113.632,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"D","height":[165,1],"flag":"ca","avg_hr":[162,0],"max_hr":[177,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":"525.97","skill_b":0,"skill_gain":"14.81","np":[156,0],"hrr":["0.94",0],"hreff":["60",0],"avg_power":[152,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.5",0],"wftp":[143,0],"wkg_guess":0,"wkg1200":["2.7",0],"wkg300":["2.9",0],"wkg120":["3.2",0],"wkg60":["3.7",0],"wkg30":["4.4",0],"wkg15":["5.2",0],"wkg5":["7.0",1],"w1200":["151",0],"w300":["164",0],"w120":["179",0],"w60":["211",0],"w30":["249",0],"w15":["293",0],"w5":["392",1],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Crit City Race","f_t":"TYPE_RACE TYPE_RACE ","distance":16,"event_date":1601736300,"rt":"2875658892","laps":"8","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"3","zid":"1102266","pos":62,"position_in_cat":20,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1102266.62","lag":0,"uid":"566503692966670752","time":[1902.401,0],"time_gun":1902.401,"gap":313.75,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"C","height":[0,0],"flag":"ca","avg_hr":[169,0],"max_hr":[184,1],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":"585.19","skill_gain":0,"np":[158,0],"hrr":["0.91",0],"hreff":["61",0],"avg_power":[154,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.6",0],"wftp":[149,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["2.9",0],"wkg120":["3.3",0],"wkg60":["3.7",0],"wkg30":["4.4",0],"wkg15":["5.9",1],"wkg5":["6.2",0],"w1200":["157",0],"w300":["163",0],"w120":["188",0],"w60":["206",0],"w30":["248",0],"w15":["334",1],"w5":["349",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Sydkysten Cycling - Carl Ras Race","f_t":"TYPE_RACE TYPE_RACE ","distance":20,"event_date":1601994600,"rt":"947394567","laps":"10","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"5","zid":"1106655","pos":80,"position_in_cat":80,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1106655.80","lag":24,"uid":"568258994141508128","time":[4930.385,0],"time_gun":4930.625,"gap":1306.278,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"V","height":[0,0],"flag":"ca","avg_hr":[132,0],"max_hr":[160,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":10,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[123,0],"hrr":["0.89",0],"hreff":["62",0],"avg_power":[118,0],"avg_wkg":["2.1",0],"wkg_ftp":["2.2",0],"wftp":[129,0],"wkg_guess":0,"wkg1200":["2.4",0],"wkg300":["2.6",0],"wkg120":["2.8",0],"wkg60":["3.5",0],"wkg30":["3.7",0],"wkg15":["4.1",0],"wkg5":["4.2",0],"w1200":["136",0],"w300":["147",0],"w120":["155",0],"w60":["195",0],"w30":["211",0],"w15":["233",0],"w5":["239",0],"is_guess":0,"upg":1,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"WTRL Team Time Trial - Zone 7","f_t":"TYPE_RACE","distance":43,"event_date":1602200100,"rt":"604330868","laps":"2","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1121958","pos":33,"position_in_cat":33,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1121958.33","lag":7,"uid":"3156197863137311192","time":[5978.016,0],"time_gun":5978.016,"gap":1192.669,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[163,0],"max_hr":[175,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":"585.19","skill_gain":0,"np":[145,0],"hrr":["0.87",0],"hreff":["65",0],"avg_power":[141,0],"avg_wkg":["2.5",0],"wkg_ftp":["2.5",0],"wftp":[140,0],"wkg_guess":0,"wkg1200":["2.6",0],"wkg300":["2.9",0],"wkg120":["3.0",0],"wkg60":["3.2",0],"wkg30":["3.5",0],"wkg15":["4.1",0],"wkg5":["5.4",0],"w1200":["148",0],"w300":["164",0],"w120":["171",0],"w60":["181",0],"w30":["199",0],"w15":["229",0],"w5":["306",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - AMERICAS W (WOMEN)","f_t":"TYPE_RACE TYPE_RACE ","distance":50,"event_date":1602639900,"rt":"3921412335","laps":"","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"2","zid":"1139267","pos":40,"position_in_cat":40,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1139267.40","lag":1,"uid":"3158201863137311192","time":[2447.491,0],"time_gun":2447.551,"gap":271.537,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[168,0],"max_hr":[184,1],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[161,0],"hrr":["0.95",0],"hreff":["59",0],"avg_power":[160,0],"avg_wkg":["2.8",0],"wkg_ftp":["2.7",0],"wftp":[156,0],"wkg_guess":0,"wkg1200":["2.9",0],"wkg300":["3.2",0],"wkg120":["3.3",0],"wkg60":["3.6",0],"wkg30":["4.3",0],"wkg15":["4.7",0],"wkg5":["5.1",0],"w1200":["165",0],"w300":["178",0],"w120":["183",0],"w60":["202",0],"w30":["241",0],"w15":["266",0],"w5":["286",0],"is_guess":0,"upg":1,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - AMERICAS W (WOMEN) - TTT","f_t":"TYPE_RACE","distance":25,"event_date":1603244700,"rt":"1776635757","laps":"1","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1154781","pos":40,"position_in_cat":40,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1154781.40","lag":0,"uid":"3159972963137311192","time":[3790.579,0],"time_gun":3790.579,"gap":772.717,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[166,0],"max_hr":[177,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":"585.19","skill_gain":0,"np":[159,0],"hrr":["0.93",0],"hreff":["60",0],"avg_power":[155,0],"avg_wkg":["2.8",0],"wkg_ftp":["2.7",0],"wftp":[152,0],"wkg_guess":0,"wkg1200":["2.9",0],"wkg300":["3.1",0],"wkg120":["3.2",0],"wkg60":["3.6",0],"wkg30":["3.9",0],"wkg15":["4.3",0],"wkg5":["4.7",0],"w1200":["161",0],"w300":["172",0],"w120":["182",0],"w60":["202",0],"w30":["221",0],"w15":["242",0],"w5":["264",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE TYPE_RACE ","distance":32,"event_date":1603849500,"rt":"2196019512","laps":"2","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1228095","pos":25,"position_in_cat":25,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1228095.25","lag":0,"uid":"3168135563137311192","time":[3159.605,0],"time_gun":3159.605,"gap":399.721,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[165,0],"max_hr":[183,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[154,0],"hrr":["0.92",0],"hreff":["61",0],"avg_power":[152,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.6",0],"wftp":[149,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["3.2",0],"wkg120":["3.6",0],"wkg60":["4.2",0],"wkg30":["4.9",1],"wkg15":["5.2",0],"wkg5":["5.3",0],"w1200":["157",0],"w300":["178",0],"w120":["202",0],"w60":["238",0],"w30":["276",1],"w15":["291",0],"w5":["299",0],"is_guess":0,"upg":1,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE","distance":31,"event_date":1605667500,"rt":"1880443431","laps":"1","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1261637","pos":29,"position_in_cat":29,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1261637.29","lag":0,"uid":"3171810563137311192","time":[4990.387,0],"time_gun":4990.387,"gap":663.148,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[169,0],"max_hr":[178,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":"595.88","skill_b":"585.19","skill_gain":"0.82","np":[155,0],"hrr":["0.91",0],"hreff":["62",0],"avg_power":[153,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.6",0],"wftp":[149,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["2.9",0],"wkg120":["3.2",0],"wkg60":["3.5",0],"wkg30":["3.8",0],"wkg15":["4.3",0],"wkg5":["5.1",0],"w1200":["157",0],"w300":["164",0],"w120":["180",0],"w60":["197",0],"w30":["215",0],"w15":["242",0],"w5":["285",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE TYPE_RACE ","distance":47,"event_date":1606272300,"rt":"2852153296","laps":"","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1289214","pos":25,"position_in_cat":25,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1289214.25","lag":0,"uid":"3174779063137311192","time":[2999.432,0],"time_gun":2999.432,"gap":197.223,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[164,0],"max_hr":[182,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":"498.52","skill_b":"584.37","skill_gain":"20.30","np":[155,0],"hrr":["0.95",0],"hreff":["59",0],"avg_power":[156,0],"avg_wkg":["2.8",0],"wkg_ftp":["2.6",0],"wftp":[148,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["3.0",0],"wkg120":["3.4",0],"wkg60":["3.7",0],"wkg30":["4.1",0],"wkg15":["4.3",0],"wkg5":["4.5",0],"w1200":["156",0],"w300":["169",0],"w120":["190",0],"w60":["206",0],"w30":["233",0],"w15":["240",0],"w5":["254",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE TYPE_RACE ","distance":28,"event_date":1606877100,"rt":"1064303857","laps":"1","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1347837","pos":37,"position_in_cat":37,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1347837.37","lag":0,"uid":"3181093163137311192","time":[3881.264,0],"time_gun":3881.264,"gap":354.153,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"A","height":[165,1],"flag":"ca","avg_hr":[160,0],"max_hr":[178,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[152,0],"hrr":["0.96",0],"hreff":["58",0],"avg_power":[153,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.6",0],"wftp":[150,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["3.0",0],"wkg120":["3.3",0],"wkg60":["3.7",0],"wkg30":["3.9",0],"wkg15":["4.0",0],"wkg5":["4.4",0],"w1200":["158",0],"w300":["167",0],"w120":["183",0],"w60":["206",0],"w30":["217",0],"w15":["225",0],"w5":["247",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE","distance":36,"event_date":1608086700,"rt":"3366225080","laps":"2","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"1","zid":"1389185","pos":34,"position_in_cat":34,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1389185.34","lag":5,"uid":"3185527763137311192","time":[4781.411,0],"time_gun":4781.411,"gap":816.312,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"V","height":[165,1],"flag":"ca","avg_hr":[151,0],"max_hr":[178,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":"564.07","skill_gain":0,"np":[144,0],"hrr":["0.88",0],"hreff":["63",0],"avg_power":[133,0],"avg_wkg":["2.4",0],"wkg_ftp":["2.5",0],"wftp":[143,0],"wkg_guess":0,"wkg1200":["2.7",0],"wkg300":["3.1",0],"wkg120":["3.3",0],"wkg60":["3.9",0],"wkg30":["4.2",0],"wkg15":["4.7",0],"wkg5":["6.6",0],"w1200":["151",0],"w300":["173",0],"w120":["188",0],"w60":["218",0],"w30":["239",0],"w15":["263",0],"w5":["374",0],"is_guess":0,"upg":1,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"WTRL Team Time Trial Platinum League","f_t":"TYPE_RACE TYPE_RACE ","distance":32,"event_date":1608835500,"rt":"2843604888","laps":"","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"3","zid":"1497992","pos":54,"position_in_cat":15,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1497992.54","lag":0,"uid":"3197084463137311192","time":[3582.416,0],"time_gun":3582.536,"gap":238.969,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"C","height":[165,1],"flag":"ca","avg_hr":[163,0],"max_hr":[183,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"27","zada":0,"note":"","div":30,"divw":30,"skill":"582.68","skill_b":"578.88","skill_gain":"3.46","np":[164,0],"hrr":["0.93",0],"hreff":["60",0],"avg_power":[152,0],"avg_wkg":["2.7",0],"wkg_ftp":["2.6",0],"wftp":[148,0],"wkg_guess":0,"wkg1200":["2.8",0],"wkg300":["3.4",1],"wkg120":["3.8",1],"wkg60":["4.6",1],"wkg30":["4.8",0],"wkg15":["5.6",0],"wkg5":["6.6",0],"w1200":["156",0],"w300":["191",0],"w120":["213",1],"w60":["257",1],"w30":["271",0],"w15":["314",0],"w5":["371",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE TYPE_RACE ","distance":32,"event_date":1610505900,"rt":"1039983620","laps":"2","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"3","zid":"1644250","pos":51,"position_in_cat":9,"name":"&Ouml;zge Yazar [REVO]","cp":1,"zwid":1261784,"res_id":"1644250.51","lag":0,"uid":"3212539963137311192","time":[2955.764,0],"time_gun":2955.884,"gap":45.874,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"C","height":[165,1],"flag":"ca","avg_hr":[171,1],"max_hr":[180,0],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":1,"age":"27","zada":0,"note":"","div":30,"divw":30,"skill":"503.87","skill_b":"575.42","skill_gain":"19.23","np":[179,1],"hrr":["1.00",1],"hreff":["56",1],"avg_power":[171,1],"avg_wkg":["3.0",1],"wkg_ftp":["2.9",1],"wftp":[163,1],"wkg_guess":0,"wkg1200":["3.1",1],"wkg300":["3.4",1],"wkg120":["3.6",0],"wkg60":["3.9",0],"wkg30":["4.5",0],"wkg15":["5.4",0],"wkg5":["6.6",0],"w1200":["172",1],"w300":["192",1],"w120":["204",0],"w60":["219",0],"w30":["256",0],"w15":["303",0],"w5":["369",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"Zwift Racing League | WTRL - Womens AMERICAS W DIVISION 1","f_t":"TYPE_RACE TYPE_RACE ","distance":28,"event_date":1612320300,"rt":"2007026433","laps":"2","dur":""},{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"4","zid":"1118313","pos":5,"position_in_cat":0,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1118313.5","lag":31,"uid":"3155754963137311192","time":[3600,0],"time_gun":3600,"gap":0,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"N\/A","height":[165,1],"flag":"ca","avg_hr":[134,0],"max_hr":[152,1],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":10,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[107,0],"hrr":["0.76",0],"hreff":["73",0],"avg_power":[102,0],"avg_wkg":["1.8",0],"wkg_ftp":["1.7",0],"wftp":[100,0],"wkg_guess":0,"wkg1200":["1.9",0],"wkg300":["2.0",0],"wkg120":["2.1",0],"wkg60":["2.5",0],"wkg30":["2.6",0],"wkg15":["2.7",0],"wkg5":["2.7",0],"w1200":["106",0],"w300":["114",0],"w120":["121",0],"w60":["141",0],"w30":["148",0],"w15":["150",0],"w5":["150",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"REVO Social SUB2","f_t":"TYPE_RIDE","distance":0,"event_date":"","rt":"1776635757","laps":"","dur":"3600"}]}`
 
 const testevent = `{"DT_RowId":"","ftp":"170","friend":0,"pt":"","label":"4","zid":"1118313","pos":5,"position_in_cat":0,"name":"&Ouml;zge Yazar [REVO]","cp":0,"zwid":1261784,"res_id":"1118313.5","lag":31,"uid":"3155754963137311192","time":[3600,0],"time_gun":3600,"gap":0,"vtta":"","vttat":0,"male":0,"tid":"2672","topen":"","tname":"REVO","tc":"fc00e3","tbc":"000000","tbd":"fc00e3","zeff":0,"category":"N\/A","height":[165,1],"flag":"ca","avg_hr":[134,0],"max_hr":[152,1],"hrmax":[0,0],"hrm":1,"weight":["56.3",1],"power_type":3,"display_pos":1,"src":10,"age":"26","zada":0,"note":"","div":30,"divw":30,"skill":0,"skill_b":0,"skill_gain":0,"np":[107,0],"hrr":["0.76",0],"hreff":["73",0],"avg_power":[102,0],"avg_wkg":["1.8",0],"wkg_ftp":["1.7",0],"wftp":[100,0],"wkg_guess":0,"wkg1200":["1.9",0],"wkg300":["2.0",0],"wkg120":["2.1",0],"wkg60":["2.5",0],"wkg30":["2.6",0],"wkg15":["2.7",0],"wkg5":["2.7",0],"w1200":["106",0],"w300":["114",0],"w120":["121",0],"w60":["141",0],"w30":["148",0],"w15":["150",0],"w5":["150",0],"is_guess":0,"upg":0,"penalty":"","reg":1,"fl":"","pts":"","pts_pos":"","info":0,"info_notes":[],"strike":-1,"event_title":"REVO Social SUB2","f_t":"TYPE_RIDE","distance":0,"event_date":1602590400,"rt":"1776635757","laps":"","dur":"3600"}`
+
+// recentEvents needs two event dates filling in
+const recentEvents = `{"data":[{"event_title":"ZZRC SUB 2.0 Ride","f_t":"TYPE_RIDE","event_date":%d,"avg_wkg":["2.2",0],"wkg_ftp":["2.3",0]},{"event_title":"Stage 4 Race - Tour of Watopia 2020","f_t":"TYPE_RACE TYPE_RACE ","event_date":%d,"avg_wkg":["2.7",0],"wkg_ftp":[2.5,0]}]}`
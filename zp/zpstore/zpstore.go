@@ -0,0 +1,499 @@
+// Package zpstore keeps the history of club imports in a local SQLite database: each run, each rider's stats
+// as of that run, and every event seen.
+package zpstore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+
+	// Pure Go SQLite driver, so there's no cgo to worry about in the container
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	club_id     INTEGER NOT NULL,
+	started_at  INTEGER NOT NULL,
+	as_of       INTEGER NOT NULL,
+	finished_at INTEGER,
+	imported    INTEGER NOT NULL DEFAULT 0,
+	failed      INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS riders (
+	zwid       INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+	run_id              INTEGER NOT NULL REFERENCES runs(id),
+	zwid                INTEGER NOT NULL,
+	name                TEXT NOT NULL,
+	as_of               INTEGER NOT NULL,
+	latest_event_date   INTEGER,
+	latest_event        TEXT NOT NULL,
+	rides               INTEGER NOT NULL,
+	races               INTEGER NOT NULL,
+	races90             INTEGER NOT NULL,
+	races30             INTEGER NOT NULL,
+	ftp90               REAL NOT NULL,
+	ftp60               REAL NOT NULL,
+	ftp30               REAL NOT NULL,
+	latest_race         TEXT NOT NULL,
+	latest_race_date    INTEGER,
+	latest_race_avg_wkg REAL NOT NULL,
+	latest_race_wkg_ftp REAL NOT NULL,
+	category            TEXT NOT NULL DEFAULT '',
+	women_category      TEXT NOT NULL DEFAULT '',
+	category_wkg        REAL NOT NULL DEFAULT 0,
+	category_watts      REAL NOT NULL DEFAULT 0,
+	upgrade_risk        INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (run_id, zwid)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	zwid            INTEGER NOT NULL,
+	event_id        TEXT NOT NULL,
+	event_date      INTEGER,
+	event_title     TEXT NOT NULL,
+	event_type      TEXT NOT NULL,
+	category        TEXT NOT NULL,
+	position        INTEGER NOT NULL,
+	position_in_cat INTEGER NOT NULL,
+	seconds         REAL,
+	distance        REAL,
+	avg_wkg         REAL,
+	wkg_ftp         REAL,
+	avg_power       REAL,
+	np              REAL,
+	avg_hr          REAL,
+	max_hr          REAL,
+	weight          REAL,
+	height          REAL,
+	w5              REAL,
+	w60             REAL,
+	w300            REAL,
+	w1200           REAL,
+	wkg5            REAL,
+	wkg60           REAL,
+	wkg300          REAL,
+	wkg1200         REAL,
+	wftp            REAL,
+	first_run_id    INTEGER NOT NULL,
+	last_run_id     INTEGER NOT NULL,
+	PRIMARY KEY (zwid, event_id)
+);
+`
+
+// addedColumns are columns added to tables since they were first created, which older databases need adding
+var addedColumns = []struct{ table, column, definition string }{
+	{"snapshots", "category", "TEXT NOT NULL DEFAULT ''"},
+	{"snapshots", "women_category", "TEXT NOT NULL DEFAULT ''"},
+	{"snapshots", "category_wkg", "REAL NOT NULL DEFAULT 0"},
+	{"snapshots", "category_watts", "REAL NOT NULL DEFAULT 0"},
+	{"snapshots", "upgrade_risk", "INTEGER NOT NULL DEFAULT 0"},
+	{"events", "wftp", "REAL"},
+}
+
+// Store is the history database
+type Store struct {
+	db *sql.DB
+}
+
+// Run is one import of a club's data
+type Run struct {
+	ID         int64
+	ClubID     int
+	StartedAt  time.Time
+	AsOf       time.Time
+	FinishedAt time.Time
+	Imported   int
+	Failed     int
+}
+
+// Open opens the database in filename, creating it if need be
+func Open(filename string) (*Store, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", filename, err)
+	}
+
+	// SQLite only allows one writer at a time, so don't let database/sql pretend otherwise
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating tables in %s: %v", filename, err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("updating tables in %s: %v", filename, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// migrate adds any columns that the database was created without
+func migrate(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// StartRun records the start of an import for the club
+func (s *Store) StartRun(clubID int, asOf time.Time) (Run, error) {
+	run := Run{ClubID: clubID, StartedAt: time.Now(), AsOf: asOf}
+	if run.AsOf.IsZero() {
+		run.AsOf = run.StartedAt
+	}
+
+	res, err := s.db.Exec(`INSERT INTO runs (club_id, started_at, as_of) VALUES (?, ?, ?)`,
+		clubID, run.StartedAt.Unix(), run.AsOf.Unix())
+	if err != nil {
+		return run, fmt.Errorf("starting run: %v", err)
+	}
+
+	run.ID, err = res.LastInsertId()
+	return run, err
+}
+
+// FinishRun records how the run went
+func (s *Store) FinishRun(runID int64, imported, failed int) error {
+	_, err := s.db.Exec(`UPDATE runs SET finished_at = ?, imported = ?, failed = ? WHERE id = ?`,
+		time.Now().Unix(), imported, failed, runID)
+	if err != nil {
+		return fmt.Errorf("finishing run %d: %v", runID, err)
+	}
+	return nil
+}
+
+// SaveProfile records the rider's stats for this run, and upserts their events. It's safe to save the same
+// profile more than once.
+func (s *Store) SaveProfile(runID int64, p zp.RiderProfile) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r := p.Rider
+	_, err = tx.Exec(`INSERT INTO riders (zwid, name, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (zwid) DO UPDATE SET name = excluded.name, updated_at = excluded.updated_at`,
+		r.Zwid, r.Name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("saving rider %d: %v", r.Zwid, err)
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO snapshots (run_id, zwid, name, as_of, latest_event_date, latest_event,
+		rides, races, races90, races30, ftp90, ftp60, ftp30, latest_race, latest_race_date, latest_race_avg_wkg,
+		latest_race_wkg_ftp, category, women_category, category_wkg, category_watts, upgrade_risk)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		runID, r.Zwid, r.Name, r.AsOf.Unix(), unix(r.LatestEventDate), r.LatestEvent,
+		r.Rides, r.Races, r.Races90, r.Races30, r.Ftp90, r.Ftp60, r.Ftp30, r.LatestRace, unix(r.LatestRaceDate),
+		r.LatestRaceAvgWkg, r.LatestRaceWkgFtp, string(r.Category.Category), string(r.Category.WomenCategory),
+		r.Category.Wkg, r.Category.Watts, r.Category.UpgradeRisk)
+	if err != nil {
+		return fmt.Errorf("saving snapshot of rider %d: %v", r.Zwid, err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO events (zwid, event_id, event_date, event_title, event_type, category,
+		position, position_in_cat, seconds, distance, avg_wkg, wkg_ftp, avg_power, np, avg_hr, max_hr, weight, height,
+		w5, w60, w300, w1200, wkg5, wkg60, wkg300, wkg1200, wftp, first_run_id, last_run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (zwid, event_id) DO UPDATE SET event_date = excluded.event_date,
+		event_title = excluded.event_title, event_type = excluded.event_type, category = excluded.category,
+		position = excluded.position, position_in_cat = excluded.position_in_cat, seconds = excluded.seconds,
+		distance = excluded.distance, avg_wkg = excluded.avg_wkg, wkg_ftp = excluded.wkg_ftp,
+		avg_power = excluded.avg_power, np = excluded.np, avg_hr = excluded.avg_hr, max_hr = excluded.max_hr,
+		weight = excluded.weight, height = excluded.height, w5 = excluded.w5, w60 = excluded.w60,
+		w300 = excluded.w300, w1200 = excluded.w1200, wkg5 = excluded.wkg5, wkg60 = excluded.wkg60,
+		wkg300 = excluded.wkg300, wkg1200 = excluded.wkg1200, wftp = excluded.wftp,
+		last_run_id = excluded.last_run_id`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range p.Events {
+		key := EventKey(e)
+		_, err := stmt.Exec(r.Zwid, key, unix(e.EventDate), e.EventTitle, e.EventType, e.Category,
+			e.Position, e.PositionInCat, value(e.Time), value(e.Distance), value(e.AvgWkg), value(e.WkgFtp),
+			value(e.AvgPower), value(e.NormalizedPower), value(e.AvgHR), value(e.MaxHR), value(e.Weight),
+			value(e.Height), value(e.Watts5), value(e.Watts60), value(e.Watts300), value(e.Watts1200),
+			value(e.Wkg5), value(e.Wkg60), value(e.Wkg300), value(e.Wkg1200), value(e.WattsFtp), runID, runID)
+		if err != nil {
+			return fmt.Errorf("saving event %s for rider %d: %v", key, r.Zwid, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// EventKey identifies an event for a rider. It's ZwiftPower's event ID, or the event's date if there isn't one.
+// Events with neither are told apart by a hash of everything else about them, so that they aren't all saved as one.
+func EventKey(e zp.Event) string {
+	if e.EventID != "" {
+		return string(e.EventID)
+	}
+	if e.EventDateSecs != 0 {
+		return fmt.Sprintf("date:%d", e.EventDateSecs)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		data = []byte(e.EventTitle + "\x00" + e.EventType)
+	}
+	sum := sha256.Sum256(data)
+	return "hash:" + hex.EncodeToString(sum[:8])
+}
+
+// unix gives a time as Unix seconds, or NULL if it's zero
+func unix(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// value gives a tuple's value, or NULL if ZwiftPower didn't supply one
+func value(t zp.Tuple) interface{} {
+	if !t.Valid {
+		return nil
+	}
+	return t.Value
+}
+
+func fromUnix(secs sql.NullInt64) time.Time {
+	if !secs.Valid {
+		return time.Time{}
+	}
+	return time.Unix(secs.Int64, 0)
+}
+
+// Runs lists the club's runs, most recent first
+func (s *Store) Runs(clubID int) ([]Run, error) {
+	rows, err := s.db.Query(`SELECT id, club_id, started_at, as_of, finished_at, imported, failed FROM runs
+		WHERE club_id = ? ORDER BY id DESC`, clubID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		var started, asOf, finished sql.NullInt64
+		if err := rows.Scan(&run.ID, &run.ClubID, &started, &asOf, &finished, &run.Imported, &run.Failed); err != nil {
+			return nil, err
+		}
+		run.StartedAt, run.AsOf, run.FinishedAt = fromUnix(started), fromUnix(asOf), fromUnix(finished)
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Snapshot gives the riders' stats as they were saved in a run
+func (s *Store) Snapshot(runID int64) ([]zp.Rider, error) {
+	rows, err := s.db.Query(`SELECT `+snapshotColumns+` FROM snapshots WHERE run_id = ? ORDER BY rowid`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var riders []zp.Rider
+	for rows.Next() {
+		r, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		riders = append(riders, r)
+	}
+	return riders, rows.Err()
+}
+
+// LatestSnapshot gives the rider's stats from the most recent run they were in. ok is false if they haven't been
+// in any.
+func (s *Store) LatestSnapshot(zwid int) (rider zp.Rider, ok bool, err error) {
+	rows, err := s.db.Query(`SELECT `+snapshotColumns+` FROM snapshots WHERE zwid = ?
+		ORDER BY run_id DESC LIMIT 1`, zwid)
+	if err != nil {
+		return rider, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return rider, false, rows.Err()
+	}
+	rider, err = scanSnapshot(rows)
+	return rider, err == nil, err
+}
+
+// snapshotColumns are the snapshots table's columns that scanSnapshot reads, in order
+const snapshotColumns = `zwid, name, as_of, latest_event_date, latest_event, rides, races, races90, races30, ftp90,
+	ftp60, ftp30, latest_race, latest_race_date, latest_race_avg_wkg, latest_race_wkg_ftp, category, women_category,
+	category_wkg, category_watts, upgrade_risk`
+
+// scanSnapshot reads a rider from a row of snapshotColumns
+func scanSnapshot(rows *sql.Rows) (zp.Rider, error) {
+	var r zp.Rider
+	var asOf, latestEvent, latestRace sql.NullInt64
+	var category, womenCategory string
+	err := rows.Scan(&r.Zwid, &r.Name, &asOf, &latestEvent, &r.LatestEvent, &r.Rides, &r.Races, &r.Races90,
+		&r.Races30, &r.Ftp90, &r.Ftp60, &r.Ftp30, &r.LatestRace, &latestRace, &r.LatestRaceAvgWkg,
+		&r.LatestRaceWkgFtp, &category, &womenCategory, &r.Category.Wkg, &r.Category.Watts,
+		&r.Category.UpgradeRisk)
+	if err != nil {
+		return r, err
+	}
+	r.AsOf, r.LatestEventDate, r.LatestRaceDate = fromUnix(asOf), fromUnix(latestEvent), fromUnix(latestRace)
+	r.Category.Category, r.Category.WomenCategory = zp.Category(category), zp.Category(womenCategory)
+	return r, nil
+}
+
+// RiderSnapshot is a rider's stats as of one run
+type RiderSnapshot struct {
+	Run   Run
+	Rider zp.Rider
+}
+
+// History gives a rider's stats from every run they were in, oldest first, for charting trends
+func (s *Store) History(zwid int) ([]RiderSnapshot, error) {
+	rows, err := s.db.Query(`SELECT r.id, r.club_id, r.started_at, s.name, s.as_of, s.ftp30, s.ftp60, s.ftp90,
+		s.races30, s.races90, s.rides, s.races, s.latest_event_date
+		FROM snapshots s JOIN runs r ON r.id = s.run_id WHERE s.zwid = ? ORDER BY s.as_of, r.id`, zwid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []RiderSnapshot
+	for rows.Next() {
+		snap := RiderSnapshot{Rider: zp.Rider{Zwid: zwid}}
+		r := &snap.Rider
+		var started, asOf, latestEvent sql.NullInt64
+		err := rows.Scan(&snap.Run.ID, &snap.Run.ClubID, &started, &r.Name, &asOf, &r.Ftp30, &r.Ftp60, &r.Ftp90,
+			&r.Races30, &r.Races90, &r.Rides, &r.Races, &latestEvent)
+		if err != nil {
+			return nil, err
+		}
+		snap.Run.StartedAt, snap.Run.AsOf = fromUnix(started), fromUnix(asOf)
+		r.AsOf, r.LatestEventDate = snap.Run.AsOf, fromUnix(latestEvent)
+		history = append(history, snap)
+	}
+	return history, rows.Err()
+}
+
+// eventColumns are the events table's columns that scanEvent reads, in order
+const eventColumns = `event_id, event_date, event_title, event_type, category, position, position_in_cat, seconds,
+	distance, avg_wkg, wkg_ftp, avg_power, np, avg_hr, max_hr, weight, height, w5, w60, w300, w1200, wkg5, wkg60,
+	wkg300, wkg1200, wftp`
+
+// Events gives every event we've seen for the rider, oldest first
+func (s *Store) Events(zwid int) ([]zp.Event, error) {
+	rows, err := s.db.Query(`SELECT `+eventColumns+`
+		FROM events WHERE zwid = ? ORDER BY event_date, event_id`, zwid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []zp.Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RunEvents gives every event we've seen for each rider in the run, oldest first, by Zwift ID
+func (s *Store) RunEvents(runID int64) (map[int][]zp.Event, error) {
+	rows, err := s.db.Query(`SELECT e.zwid, `+prefixColumns("e.", eventColumns)+`
+		FROM events e JOIN snapshots s ON s.zwid = e.zwid WHERE s.run_id = ?
+		ORDER BY e.zwid, e.event_date, e.event_id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := map[int][]zp.Event{}
+	for rows.Next() {
+		var zwid int
+		e, err := scanEvent(rows, &zwid)
+		if err != nil {
+			return nil, err
+		}
+		events[zwid] = append(events[zwid], e)
+	}
+	return events, rows.Err()
+}
+
+// prefixColumns puts prefix in front of each of a comma-separated list of columns
+func prefixColumns(prefix, columns string) string {
+	names := strings.Split(columns, ",")
+	for i, name := range names {
+		names[i] = prefix + strings.TrimSpace(name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// scanEvent reads an event from a row of eventColumns, after any extra columns scanned into before
+func scanEvent(rows *sql.Rows, before ...interface{}) (zp.Event, error) {
+	var e zp.Event
+	var id string
+	var date sql.NullInt64
+	tuples := []*zp.Tuple{&e.Time, &e.Distance, &e.AvgWkg, &e.WkgFtp, &e.AvgPower, &e.NormalizedPower,
+		&e.AvgHR, &e.MaxHR, &e.Weight, &e.Height, &e.Watts5, &e.Watts60, &e.Watts300, &e.Watts1200,
+		&e.Wkg5, &e.Wkg60, &e.Wkg300, &e.Wkg1200, &e.WattsFtp}
+	values := make([]sql.NullFloat64, len(tuples))
+	dest := append(before, &id, &date, &e.EventTitle, &e.EventType, &e.Category, &e.Position, &e.PositionInCat)
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return e, err
+	}
+
+	if !isMadeUpKey(id) {
+		e.EventID = zp.ID(id)
+	}
+	e.EventDate = fromUnix(date)
+	e.EventDateSecs = zp.EventDateType(date.Int64)
+	for i, v := range values {
+		*tuples[i] = zp.Tuple{Value: v.Float64, Valid: v.Valid}
+	}
+	return e, nil
+}
+
+// isMadeUpKey says whether an event key is one EventKey made up, rather than ZwiftPower's event ID
+func isMadeUpKey(key string) bool {
+	return strings.HasPrefix(key, "date:") || strings.HasPrefix(key, "hash:")
+}
@@ -0,0 +1,209 @@
+package zpstore
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hermannatorii/zwiftpower/zp"
+	"github.com/hermannatorii/zwiftpower/zp/zptest"
+)
+
+func openTemp(t *testing.T) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func importProfile(t *testing.T, zwid int, asOf time.Time) zp.RiderProfile {
+	srv := zptest.NewServer("../testdata")
+	defer srv.Close()
+
+	client, err := zp.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.BaseURL = srv.URL
+	client.AsOf = asOf
+
+	profile, err := zp.ImportRiderEvents(client, zwid)
+	if err != nil {
+		t.Fatalf("ImportRiderEvents: %v", err)
+	}
+	profile.Rider.Name = "Özge Yazar"
+	return profile
+}
+
+func TestSaveProfile(t *testing.T) {
+	s := openTemp(t)
+
+	asOf := []time.Time{
+		time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 2, 15, 0, 0, 0, 0, time.UTC),
+	}
+
+	var runs []Run
+	for _, a := range asOf {
+		run, err := s.StartRun(2672, a)
+		if err != nil {
+			t.Fatalf("StartRun: %v", err)
+		}
+		profile := importProfile(t, 1261784, a)
+
+		// Saving twice in the same run mustn't duplicate anything
+		for i := 0; i < 2; i++ {
+			if err := s.SaveProfile(run.ID, profile); err != nil {
+				t.Fatalf("SaveProfile: %v", err)
+			}
+		}
+		if err := s.FinishRun(run.ID, 1, 0); err != nil {
+			t.Fatalf("FinishRun: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	got, err := s.Runs(2672)
+	if err != nil {
+		t.Fatalf("Runs: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != runs[1].ID || got[1].ID != runs[0].ID {
+		t.Fatalf("Runs: got %+v", got)
+	}
+	if got[0].Imported != 1 || got[0].FinishedAt.IsZero() || !got[0].AsOf.Equal(asOf[1]) {
+		t.Errorf("Run not finished properly: %+v", got[0])
+	}
+
+	snap, err := s.Snapshot(runs[1].ID)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if len(snap) != 1 {
+		t.Fatalf("Snapshot: got %d riders, want 1", len(snap))
+	}
+	want := importProfile(t, 1261784, asOf[1]).Rider
+	r := snap[0]
+	if r.Name != want.Name || r.Ftp30 != want.Ftp30 || r.Races != want.Races || r.LatestRace != want.LatestRace ||
+		!r.LatestRaceDate.Equal(want.LatestRaceDate) || !r.AsOf.Equal(asOf[1]) || r.Category != want.Category {
+		t.Errorf("Snapshot: got %+v, want %+v", r, want)
+	}
+
+	history, err := s.History(1261784)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("History: got %d snapshots, want 2", len(history))
+	}
+	if history[0].Run.ID != runs[0].ID || history[1].Rider.Ftp30 != want.Ftp30 {
+		t.Errorf("History: got %+v", history)
+	}
+
+	events, err := s.Events(1261784)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	profile := importProfile(t, 1261784, time.Time{})
+	if len(events) != len(profile.Events) {
+		t.Fatalf("Events: got %d, want %d", len(events), len(profile.Events))
+	}
+
+	var undated int
+	for _, e := range events {
+		if e.EventDate.IsZero() {
+			undated++
+			continue
+		}
+		if e.EventID == "" {
+			t.Errorf("Dated event %q has no ID", e.EventTitle)
+		}
+	}
+	if undated != 1 {
+		t.Errorf("Got %d undated events, want 1", undated)
+	}
+
+	byRider, err := s.RunEvents(runs[1].ID)
+	if err != nil {
+		t.Fatalf("RunEvents: %v", err)
+	}
+	if len(byRider) != 1 || len(byRider[1261784]) != len(events) || byRider[1261784][0] != events[0] {
+		t.Errorf("RunEvents: got %d riders, %d events for the rider, want 1 and %d",
+			len(byRider), len(byRider[1261784]), len(events))
+	}
+
+	latest := events[len(events)-1]
+	if latest.WkgFtp.Value != 2.9 || !latest.WkgFtp.Valid || latest.WattsFtp.Value != 163 || !latest.WattsFtp.Valid ||
+		latest.EventDate.Unix() != 1612320300 {
+		t.Errorf("Latest event: got %+v", latest)
+	}
+
+	// Re-importing as of an earlier date is still the latest run
+	run, err := s.StartRun(2672, asOf[0])
+	if err != nil {
+		t.Fatalf("StartRun: %v", err)
+	}
+	if err := s.SaveProfile(run.ID, importProfile(t, 1261784, asOf[0])); err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	r, ok, err := s.LatestSnapshot(1261784)
+	if err != nil || !ok || !r.AsOf.Equal(asOf[0]) {
+		t.Errorf("LatestSnapshot: got %+v, %v, %v", r, ok, err)
+	}
+	if _, ok, err := s.LatestSnapshot(1); ok || err != nil {
+		t.Errorf("LatestSnapshot of a missing rider: got %v, %v", ok, err)
+	}
+}
+
+func TestEventKey(t *testing.T) {
+	social := zp.Event{EventTitle: "REVO Social SUB2", EventType: "GROUP"}
+	race := zp.Event{EventTitle: "REVO Race", EventType: "RACE"}
+
+	if EventKey(social) == EventKey(race) {
+		t.Errorf("Different undated events got the same key %s", EventKey(social))
+	}
+	if EventKey(social) != EventKey(social) {
+		t.Errorf("The same event got different keys")
+	}
+	if !isMadeUpKey(EventKey(social)) {
+		t.Errorf("Key %s would be read back as an event ID", EventKey(social))
+	}
+	if k := EventKey(zp.Event{EventID: "1234", EventTitle: "REVO Race"}); k != "1234" {
+		t.Errorf("Got key %s for an event with an ID", k)
+	}
+}
+
+func TestOpenOldDatabase(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The snapshots table as it was before categories were saved
+	_, err = db.Exec(`CREATE TABLE snapshots (run_id INTEGER NOT NULL, zwid INTEGER NOT NULL, name TEXT NOT NULL,
+		as_of INTEGER NOT NULL, latest_event_date INTEGER, latest_event TEXT NOT NULL, rides INTEGER NOT NULL,
+		races INTEGER NOT NULL, races90 INTEGER NOT NULL, races30 INTEGER NOT NULL, ftp90 REAL NOT NULL,
+		ftp60 REAL NOT NULL, ftp30 REAL NOT NULL, latest_race TEXT NOT NULL, latest_race_date INTEGER,
+		latest_race_avg_wkg REAL NOT NULL, latest_race_wkg_ftp REAL NOT NULL, PRIMARY KEY (run_id, zwid));
+		INSERT INTO snapshots VALUES (1, 98588, 'Liz Rice', 0, NULL, '', 0, 0, 0, 0, 0, 0, 2.5, '', NULL, 0, 0)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(filename)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	snap, err := s.Snapshot(1)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if len(snap) != 1 || snap[0].Ftp30 != 2.5 || snap[0].Category != (zp.CategoryEstimate{}) {
+		t.Errorf("Snapshot: got %+v", snap)
+	}
+}
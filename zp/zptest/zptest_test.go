@@ -0,0 +1,70 @@
+package zptest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cache3/teams/2740_riders.json":
+			w.Write([]byte(`{"data":[{"zwid":98588}]}`))
+		case "/cache3/profile/98588_all.json":
+			w.Write([]byte(`{"data":[]}`))
+		default:
+			w.Write([]byte(`<html></html>`))
+		}
+	}))
+	defer site.Close()
+
+	dir := t.TempDir()
+
+	client := &http.Client{Transport: &Recorder{Dir: dir}}
+	for _, path := range []string{"/cache3/teams/2740_riders.json", "/cache3/profile/98588_all.json", "/profile.php"} {
+		resp, err := client.Get(site.URL + path)
+		if err != nil {
+			t.Fatalf("Getting %s: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(files) != 2 {
+		t.Errorf("Recorded %v, expected two files", files)
+	}
+
+	srv := NewServer(dir)
+	defer srv.Close()
+
+	cases := []struct {
+		path     string
+		status   int
+		expected string
+	}{
+		{path: "/cache3/teams/2740_riders.json", status: http.StatusOK, expected: `{"data":[{"zwid":98588}]}`},
+		{path: "/cache3/profile/98588_all.json", status: http.StatusOK, expected: `{"data":[]}`},
+		{path: "/cache3/profile/1_all.json", status: http.StatusNotFound},
+		{path: "/profile.php", status: http.StatusOK},
+		{path: "/cache3/../etc/passwd", status: http.StatusNotFound},
+	}
+
+	for i, c := range cases {
+		resp, err := http.Get(srv.URL + c.path)
+		if err != nil {
+			t.Fatalf("Case %d: %v", i, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != c.status {
+			t.Errorf("Case %d: got status %d expected %d", i, resp.StatusCode, c.status)
+		}
+		if c.expected != "" && string(body) != c.expected {
+			t.Errorf("Case %d: got %s expected %s", i, body, c.expected)
+		}
+	}
+}